@@ -75,9 +75,28 @@ func main() {
 	// Create the services needed for the mode processor
 	// They can be overridden by the mode processor with different implementations
 	// Config service
+	// If a config file is provided, it overrides the hard-coded configuration
 	cfgSvc := config.NewHardCoded()
+	if cfgFile := os.Getenv("CONFIG_FILE"); cfgFile != "" {
+		var err error
+		cfgSvc, err = config.NewFile(canxCtx, cfgFile)
+		if err != nil {
+			lgr.Logger.Error("error loading config file", slog.String("file", cfgFile), slog.Any("error", xerrors.New(err.Error())))
+			panic("error loading config file")
+		}
+	}
 	// Data service
-	dataSvc := data.NewFilesDB(cfgSvc)
+	// If a SQLite database file is provided, it replaces the files DB
+	// Camera records are validated against the registered framers so custom framers are accepted too
+	dataSvc := data.NewFilesDB(cfgSvc, pipeline.FramerNames)
+	if dbFile := os.Getenv("SQLITE_FILE"); dbFile != "" {
+		var err error
+		dataSvc, err = data.NewSQLite(cfgSvc, dbFile, pipeline.FramerNames)
+		if err != nil {
+			lgr.Logger.Error("error opening sqlite database", slog.String("file", dbFile), slog.Any("error", xerrors.New(err.Error())))
+			panic("error opening sqlite database")
+		}
+	}
 	// Orphan service
 	orphanSvc := orphan.NewTimed(canxCtx, cfgSvc, dataSvc)
 	// storage service
@@ -103,19 +122,38 @@ func main() {
 	modeProcResult := make(chan error)
 	defer close(modeProcResult)
 
-	// Decide on streamers
-	// Change this to use a dynamic way to configure streamers
-	streamers := []pipeline.Streamer{
-		//pipeline.SimpleDetector,
-		// pipeline.MP4Recorder,
-		pipeline.Yolo5Detector,
+	// Decide on the default streamers (cameras may list their own)
+	// Streamers are looked up by name in the pipeline registry so
+	// custom streamers must be registered via `pipeline.RegisterStreamer` first
+	streamerNames := []string{
+		// config.SimpleDetectorName,
+		// config.MP4RecorderName,
+		// config.MotionDetectorName,
+		// config.LineCounterName,
+		// config.LoiteringDetectorName,
+		config.Yolo5DetectorName,
+	}
+
+	streamers := []pipeline.Streamer{}
+	for _, name := range streamerNames {
+		streamer, err := pipeline.LookupStreamer(name)
+		if err != nil {
+			lgr.Logger.Error("invalid streamer", slog.String("streamer", name), slog.Any("error", xerrors.New(err.Error())))
+			panic("invalid streamer")
+		}
+		streamers = append(streamers, streamer)
 	}
 
 	// Use the library simple alerter
+	alerter, err := pipeline.LookupAlerter(config.SimpleAlerterName)
+	if err != nil {
+		lgr.Logger.Error("invalid alerter", slog.String("alerter", config.SimpleAlerterName), slog.Any("error", xerrors.New(err.Error())))
+		panic("invalid alerter")
+	}
 
 	// Start the mode processor
 	go func() {
-		modeProcResult <- modeProc(canxCtx, svcs, streamers, pipeline.SimpleAlerter)
+		modeProcResult <- modeProc(canxCtx, svcs, streamers, alerter)
 	}()
 
 	// Wait for cancellation, mode proc, stats or error
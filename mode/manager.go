@@ -3,7 +3,6 @@ package mode
 import (
 	"context"
 	"log/slog"
-	"math/rand"
 	"time"
 
 	"github.com/khaledhikmat/vs-go/model"
@@ -11,11 +10,6 @@ import (
 	"github.com/khaledhikmat/vs-go/service/lgr"
 )
 
-type agent struct {
-	Camera model.Camera
-	CanxFn context.CancelFunc
-}
-
 // The agents manager is responsible for running the agents
 func Manager(canxCtx context.Context, svcs pipeline.ServicesFactory, streamers []pipeline.Streamer, alerter pipeline.Alerter) error {
 	// Subscribe to the orphan service to receive orphaned cameras
@@ -43,13 +37,57 @@ func Manager(canxCtx context.Context, svcs pipeline.ServicesFactory, streamers [
 
 	// Store running agents and manager stats in memory (convert to OTEL)
 	var agentsManagerStartTime = time.Now().Unix()
-	var runningAgents = map[string]agent{}
 
 	// OTEL stats
 	agentsManagerStats := model.AgentsManagerStats{
 		TotalRunningAgentsUptime: agentsManagerStartTime,
 	}
 
+	// The supervisor runs the agents and restarts the ones that fail
+	sup := newSupervisor(canxCtx, svcs, streamers, errorStream, statsStream, alertStream, &agentsManagerStats)
+
+	// Subscribe or unsubscribe from the orphan service to match the running agents
+	// against the max agents per pod (which may change while we are running)
+	subscribed := true
+	reconcileSubscription := func() {
+		maxAgents := svcs.CfgSvc.GetMaxAgentsPerPod()
+
+		if subscribed && len(sup.Agents) >= maxAgents {
+			agentsManagerStats.TotalOrphanedRequestUnsubscriptions++
+			// Unsubscribe from the orphan service so that we don't get more cameras
+			// We want to make sure that we don't consume events that may deprive
+			// other agent pods from getting camera requests
+			err := svcs.OrphanSvc.Unsubscribe()
+			if err != nil {
+				procError(svcs.DataSvc, model.GenError("agents_manager",
+					err,
+					map[string]interface{}{},
+					"error unsubscribing from orphan service"))
+				return
+			}
+			subscribed = false
+		}
+
+		if !subscribed && len(sup.Agents) < maxAgents {
+			// If we have less than the max agents, we can re-subscribe to the orphan service
+			// Re-subscribe to the orphan service so that we can get more cameras
+			agentsManagerStats.TotalOrphanedRequestSubscriptions++
+			stream, err := svcs.OrphanSvc.Subscribe()
+			if err != nil {
+				procError(svcs.DataSvc, model.GenError("agents_manager",
+					err,
+					map[string]interface{}{},
+					"error subscribing to orphan service"))
+				return
+			}
+			orphanStream = stream
+			subscribed = true
+		}
+	}
+
+	// Watch the configuration for changes
+	cfgChanges := svcs.CfgSvc.Watch(canxCtx)
+
 	// Wait for cancellation, timeout or orphaned cameras
 	for {
 		select {
@@ -61,117 +99,28 @@ func Manager(canxCtx context.Context, svcs pipeline.ServicesFactory, streamers [
 
 		case orphanedCameras := <-orphanStream:
 			agentsManagerStats.TotalOrphanedRequests++
-			unAccomodatedCameras := []model.Camera{}
-
-			// Run each camera's agent using configured streamers
-			for _, camera := range orphanedCameras {
-				if len(runningAgents) >= svcs.CfgSvc.GetMaxAgentsPerPod() {
-					unAccomodatedCameras = append(unAccomodatedCameras, camera)
-					continue
-				}
-
-				// Create a child context for the agent
-				// to allow us to cancel an agent
-				// without cancelling the main context
-				agentCanxCtx, agentCanxFn := context.WithCancel(canxCtx)
-
-				var agentStartErr error
-
-				go func() {
-					agentStartErr = pipeline.Agent(agentCanxCtx, svcs, errorStream, statsStream, alertStream, camera, streamers)
-					if agentStartErr != nil {
-						procError(svcs.DataSvc, model.GenError("agents_manager",
-							agentStartErr,
-							map[string]interface{}{},
-							"error starting agent for camera: %s",
-							camera.Name))
-					}
-				}()
-
-				// If the agent fails to start, make sure to cancel the agent's context
-				if agentStartErr != nil {
-					// Cancel the agent's context
-					agentCanxFn()
-					continue
-				}
-
-				// Store the agent in memory
-				runningAgents[camera.ID] = agent{
-					Camera: camera,
-					CanxFn: agentCanxFn,
-				}
-			}
+			unAccomodatedCameras := sup.adopt(orphanedCameras)
 
 			// If there are unaccommodated cameras, let it be known
 			if len(unAccomodatedCameras) > 0 {
 				lgr.Logger.Debug(
 					"agents pod could not accommodate these cameras.",
-					slog.Int("runningAgents", len(runningAgents)),
+					slog.Int("runningAgents", len(sup.Agents)),
 					slog.Int("maxAgentsPerPod", svcs.CfgSvc.GetMaxAgentsPerPod()),
 					slog.Int("unAccomodatedAgents", len(unAccomodatedCameras)),
 				)
 			}
 
-			if len(runningAgents) >= svcs.CfgSvc.GetMaxAgentsPerPod() {
-				agentsManagerStats.TotalOrphanedRequestUnsubscriptions++
-				// Unsubscribe from the orphan service so that we don't get more cameras
-				// We want to make sure that we don't consume events that may deprive
-				// other agent pods from getting camera requests
-				err = svcs.OrphanSvc.Unsubscribe()
-				if err != nil {
-					procError(svcs.DataSvc, model.GenError("agents_manager",
-						err,
-						map[string]interface{}{},
-						"error unsubscribing from orphan service"))
-				}
-			}
+			reconcileSubscription()
 
 		case <-time.After(time.Duration(time.Duration(svcs.CfgSvc.GetAgentsManagerPeriodicTimeout()) * time.Second)):
-			// Monitor my running agents to see if they need to be stopped (due to exclusion)
-			// Convert runningAgents to runningAgentIDs
-			runningAgentIDs := make([]string, 0, len(runningAgents))
-			for id := range runningAgents {
-				runningAgentIDs = append(runningAgentIDs, id)
-			}
-
-			// Retrieve cameras from the data service
-			cameras, err := svcs.DataSvc.RetrieveCamerasByIDs(runningAgentIDs)
-			if err != nil {
-				procError(svcs.DataSvc, model.GenError("agents_manager",
-					err,
-					map[string]interface{}{},
-					"error retrieving cameras by IDs from the data service"))
-				continue
-			}
-
-			// I think it is better to centralize the logic in the agents manager
-			// as opposed to having each agent monitor its own commands
-			// Go through the running agents and see if they can be excluded
-			for _, camera := range cameras {
-				if camera.Excluded {
-					lgr.Logger.Debug(
-						"camera is in exclusion list",
-						slog.String("cameraID", camera.ID),
-					)
-					removeRandomAgent(runningAgents)
-				}
-			}
+			// Stop the agents of the excluded and deleted cameras and restart the changed ones
+			sup.check()
 
-			if len(runningAgents) < svcs.CfgSvc.GetMaxAgentsPerPod() {
-				// If we have less than the max agents, we can re-subscribe to the orphan service
-				// Re-subscribe to the orphan service so that we can get more cameras
-				agentsManagerStats.TotalOrphanedRequestSubscriptions++
-				_, err = svcs.OrphanSvc.Subscribe()
-				if err != nil {
-					procError(svcs.DataSvc, model.GenError("agents_manager",
-						err,
-						map[string]interface{}{},
-						"error subscribing to orphan service"))
-				}
-			}
+			reconcileSubscription()
 
 			agentsManagerStats.TotalRunningAgentsUptime = time.Now().Unix() - agentsManagerStartTime
-			agentsManagerStats.TotalRunningAgents = int64(len(runningAgents))
+			agentsManagerStats.TotalRunningAgents = int64(len(sup.Agents))
 			if agentsManagerStats.TotalRunningAgentsUptime > 0 {
 				uptimeInMinutes := float64(agentsManagerStats.TotalRunningAgentsUptime) / 60.0
 				agentsManagerStats.AvgRunningAgentsPerMin = float64(agentsManagerStats.TotalRunningAgents) / uptimeInMinutes
@@ -182,6 +131,24 @@ func Manager(canxCtx context.Context, svcs pipeline.ServicesFactory, streamers [
 			// Send the stats to OTEL
 			procStats(svcs.DataSvc, agentsManagerStats)
 
+		case exit := <-sup.Exits:
+			sup.exited(exit)
+			reconcileSubscription()
+
+		case restart := <-sup.Restarts:
+			sup.restart(restart)
+			reconcileSubscription()
+
+		case <-cfgChanges:
+			// Running agents pick up their new streamer parameters on their own
+			// but the manager must re-evaluate its subscription against the new max agents per pod
+			lgr.Logger.Info(
+				"agents manager configuration changed",
+				slog.Int("runningAgents", len(sup.Agents)),
+				slog.Int("maxAgentsPerPod", svcs.CfgSvc.GetMaxAgentsPerPod()),
+			)
+			reconcileSubscription()
+
 		case s := <-statsStream:
 			procStats(svcs.DataSvc, s)
 
@@ -221,35 +188,3 @@ resume:
 		}
 	}
 }
-
-// Randomly remove an agent from runningAgents
-func removeRandomAgent(runningAgents map[string]agent) {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
-	// Get all keys from the map
-	keys := make([]string, 0, len(runningAgents))
-	for key := range runningAgents {
-		keys = append(keys, key)
-	}
-
-	// If there are no agents, return
-	if len(keys) == 0 {
-		return
-	}
-
-	// Pick a random key
-	randomKey := keys[rand.Intn(len(keys))]
-
-	// Cancel the agent's context
-	runningAgents[randomKey].CanxFn()
-
-	// Remove the agent from the map
-	delete(runningAgents, randomKey)
-
-	// Log the removal
-	lgr.Logger.Debug(
-		"removed a random agent",
-		slog.String("cameraID", randomKey),
-	)
-}
@@ -0,0 +1,341 @@
+package mode
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/pipeline"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"github.com/khaledhikmat/vs-go/service/data"
+)
+
+// The fake framer runs until its agent is cancelled while the finite framer ends right away
+const (
+	fakeFramerName   = "fake"
+	finiteFramerName = "finite"
+)
+
+func init() {
+	err := pipeline.RegisterFramer(fakeFramerName, func(canx context.Context, _ pipeline.ServicesFactory, _ model.Camera, _ chan interface{}, _ chan interface{}, _ chan pipeline.AlertData, _ []*pipeline.Stream) error {
+		<-canx.Done()
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = pipeline.RegisterFramer(finiteFramerName, func(_ context.Context, _ pipeline.ServicesFactory, _ model.Camera, _ chan interface{}, _ chan interface{}, _ chan pipeline.AlertData, _ []*pipeline.Stream) error {
+		return pipeline.ErrSourceEnded
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// fakeData keeps the cameras and their owners in memory. The methods that the tests
+// do not need are left to the embedded (nil) interface.
+type fakeData struct {
+	data.IService
+
+	mu       sync.Mutex
+	Cameras  map[string]model.Camera
+	Claims   map[string]int
+	Released []string
+	Events   []model.CameraEvent
+}
+
+func newFakeData(cameras ...model.Camera) *fakeData {
+	svc := &fakeData{
+		Cameras: map[string]model.Camera{},
+		Claims:  map[string]int{},
+	}
+	for _, camera := range cameras {
+		if camera.FramerType == "" {
+			camera.FramerType = fakeFramerName
+		}
+		svc.Cameras[camera.ID] = camera
+	}
+
+	return svc
+}
+
+func (svc *fakeData) camera(id string) model.Camera {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.Cameras[id]
+}
+
+func (svc *fakeData) claims(id string) int {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.Claims[id]
+}
+
+func (svc *fakeData) setExcluded(id string, excluded bool) {
+	_ = svc.UpdateCameraExcluded(id, excluded)
+}
+
+func (svc *fakeData) UpdateCameraExcluded(id string, excluded bool) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	camera, ok := svc.Cameras[id]
+	if !ok {
+		return fmt.Errorf("camera %s: %w", id, data.ErrCameraNotFound)
+	}
+	camera.Excluded = excluded
+	svc.Cameras[id] = camera
+	return nil
+}
+
+func (svc *fakeData) RetrieveCamerasByID(id string) (model.Camera, error) {
+	return svc.camera(id), nil
+}
+
+func (svc *fakeData) RetrieveCamerasByIDs(ids []string) ([]model.Camera, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	cameras := []model.Camera{}
+	for _, id := range ids {
+		if camera, ok := svc.Cameras[id]; ok {
+			cameras = append(cameras, camera)
+		}
+	}
+	return cameras, nil
+}
+
+func (svc *fakeData) ClaimCamera(cameraID, agentID string, _ int) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	camera, ok := svc.Cameras[cameraID]
+	if !ok {
+		return fmt.Errorf("camera %s: %w", cameraID, data.ErrCameraNotFound)
+	}
+	if camera.AgentID != "" && camera.AgentID != agentID {
+		return fmt.Errorf("camera %s: %w", cameraID, data.ErrCameraClaimed)
+	}
+
+	camera.AgentID = agentID
+	svc.Cameras[cameraID] = camera
+	svc.Claims[cameraID]++
+	return nil
+}
+
+func (svc *fakeData) RenewLease(cameraID, agentID string, _ int) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if svc.Cameras[cameraID].AgentID != agentID {
+		return fmt.Errorf("camera %s: %w", cameraID, data.ErrCameraNotOwned)
+	}
+	return nil
+}
+
+func (svc *fakeData) ReleaseCamera(cameraID, agentID string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	camera := svc.Cameras[cameraID]
+	if agentID == "" || camera.AgentID != agentID {
+		return fmt.Errorf("camera %s: %w", cameraID, data.ErrCameraNotOwned)
+	}
+
+	camera.AgentID = ""
+	svc.Cameras[cameraID] = camera
+	svc.Released = append(svc.Released, cameraID)
+	return nil
+}
+
+func (svc *fakeData) released(id string) bool {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	for _, released := range svc.Released {
+		if released == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (svc *fakeData) NewCameraEvent(event model.CameraEvent) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.Events = append(svc.Events, event)
+	return nil
+}
+
+func (svc *fakeData) NewError(_ interface{}) error           { return nil }
+func (svc *fakeData) NewAgentStats(_ model.AgentStats) error { return nil }
+
+// fakeOrphan records the cameras that are given back
+type fakeOrphan struct {
+	mu        sync.Mutex
+	Published []model.Camera
+}
+
+func (svc *fakeOrphan) Publish(cameras []model.Camera) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.Published = append(svc.Published, cameras...)
+	return nil
+}
+
+func (svc *fakeOrphan) Subscribe() (<-chan []model.Camera, error) {
+	return make(chan []model.Camera), nil
+}
+
+func (svc *fakeOrphan) Unsubscribe() error {
+	return nil
+}
+
+// fakeConfig overrides the hardcoded configuration
+type fakeConfig struct {
+	config.IService
+	MaxAgentsPerPod int
+	MaxRestarts     int
+	RestartBackoff  int
+}
+
+func (svc *fakeConfig) GetMaxAgentsPerPod() int {
+	return svc.MaxAgentsPerPod
+}
+
+func (svc *fakeConfig) GetAgentMaxRestarts() int {
+	return svc.MaxRestarts
+}
+
+func (svc *fakeConfig) GetAgentRestartBackoff() int {
+	return svc.RestartBackoff
+}
+
+func newTestSupervisor(t *testing.T, dataSvc *fakeData) (*supervisor, *fakeOrphan) {
+	t.Helper()
+
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	t.Cleanup(canxFn)
+
+	orphanSvc := &fakeOrphan{}
+	svcs := pipeline.ServicesFactory{
+		CfgSvc: &fakeConfig{
+			IService:        config.NewHardCoded(),
+			MaxAgentsPerPod: 10,
+			MaxRestarts:     2,
+			// Backoffs are in seconds so the tests restart right away
+			RestartBackoff: 0,
+		},
+		DataSvc:   dataSvc,
+		OrphanSvc: orphanSvc,
+	}
+
+	sup := newSupervisor(canxCtx, svcs, nil,
+		make(chan interface{}),
+		make(chan interface{}),
+		make(chan pipeline.AlertData),
+		&model.AgentsManagerStats{})
+	return sup, orphanSvc
+}
+
+// waitFor polls the condition until it holds or the test times out
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExcludedCameraAgentIsStopped(t *testing.T) {
+	dataSvc := newFakeData(
+		model.Camera{ID: "1", Name: "front"},
+		model.Camera{ID: "2", Name: "back"},
+	)
+	sup, _ := newTestSupervisor(t, dataSvc)
+
+	sup.adopt([]model.Camera{{ID: "1"}, {ID: "2"}})
+	waitFor(t, "the cameras to be claimed", func() bool {
+		return dataSvc.camera("1").AgentID != "" && dataSvc.camera("2").AgentID != ""
+	})
+
+	excluded := sup.Agents["1"]
+	dataSvc.setExcluded("1", true)
+	sup.check()
+
+	// Only the excluded camera's agent is stopped
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("excluded camera agent is still running")
+	}
+	select {
+	case <-excluded.Done:
+	default:
+		t.Fatalf("excluded camera agent did not exit")
+	}
+	if _, ok := sup.Agents["2"]; !ok {
+		t.Fatalf("the other camera agent was stopped")
+	}
+
+	// Its lease is released
+	if !dataSvc.released("1") || dataSvc.camera("1").AgentID != "" {
+		t.Fatalf("excluded camera lease was not released: %+v", dataSvc.camera("1"))
+	}
+	if dataSvc.released("2") {
+		t.Fatalf("the other camera lease was released")
+	}
+
+	// And the stop is recorded
+	if len(dataSvc.Events) != 1 || dataSvc.Events[0].Type != model.CameraEventExcluded || dataSvc.Events[0].CameraID != "1" {
+		t.Fatalf("excluded event was not recorded: %+v", dataSvc.Events)
+	}
+
+	// The exit of the stopped agent does not restart it
+	select {
+	case exit := <-sup.Exits:
+		sup.exited(exit)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stopped agent did not report its exit")
+	}
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("excluded camera agent was restarted")
+	}
+
+	// Neither does the next check nor an orphan request for the camera
+	sup.check()
+	sup.adopt([]model.Camera{{ID: "1"}})
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("excluded camera agent was restarted")
+	}
+	if claims := dataSvc.claims("1"); claims != 1 {
+		t.Fatalf("excluded camera was claimed %d times", claims)
+	}
+}
+
+func TestExcludedCameraIsNotAdopted(t *testing.T) {
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "front", Excluded: true})
+	sup, _ := newTestSupervisor(t, dataSvc)
+
+	// The orphan request was published before the camera was excluded
+	sup.adopt([]model.Camera{{ID: "1"}})
+	if len(sup.Agents) != 0 {
+		t.Fatalf("excluded camera agent was started")
+	}
+
+	// Including the camera again lets it be adopted
+	dataSvc.setExcluded("1", false)
+	sup.adopt([]model.Camera{{ID: "1"}})
+	if _, ok := sup.Agents["1"]; !ok {
+		t.Fatalf("included camera agent was not started")
+	}
+	waitFor(t, "the camera to be claimed", func() bool {
+		return dataSvc.camera("1").AgentID != ""
+	})
+}
@@ -28,7 +28,7 @@ func Monitor(canxCtx context.Context, svcs pipeline.ServicesFactory, _ []pipelin
 
 		case <-time.After(time.Duration(time.Duration(svcs.CfgSvc.GetAgentsMonitorPeriodicTimeout()) * time.Second)):
 			// Retrieve orphaned cameras
-			cameras, err := svcs.DataSvc.RetrieveOrphanedCameras(10)
+			cameras, err := svcs.DataSvc.RetrieveOrphanedCameras(svcs.CfgSvc.GetAgentsMonitorMaxOrphanedCameras())
 			if err != nil {
 				errorStream <- model.GenError("agents_monitor",
 					err,
@@ -0,0 +1,478 @@
+package mode
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/pipeline"
+	"github.com/khaledhikmat/vs-go/service/data"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+)
+
+const (
+	// How long the supervisor waits for a stopped agent to release its camera
+	agentStopTimeout = 10 * time.Second
+	// An agent that ran this long is considered healthy and its failures are forgotten
+	agentHealthyRuntime = 5 * time.Minute
+)
+
+type agent struct {
+	Camera model.Camera
+	// ID is the agent ID that claims the camera. Every run gets its own ID.
+	ID     string
+	CanxFn context.CancelFunc
+	// Done is closed when the agent exits
+	Done chan struct{}
+	// Generation tells apart the runs of the same camera so that
+	// exits and restarts of a previous run are ignored
+	Generation int64
+	StartTime  time.Time
+	Failures   int
+	// Restarting is set while the agent waits for its backoff to expire.
+	// The agent keeps its slot in the pod while restarting.
+	Restarting bool
+}
+
+type agentExit struct {
+	CameraID   string
+	Generation int64
+	Err        error
+}
+
+type agentRestart struct {
+	CameraID   string
+	Generation int64
+}
+
+// The supervisor runs the agents of the agents manager and watches them exit.
+// Failed agents are restarted with exponential backoff and jitter and a camera
+// whose agent keeps failing is given back through the orphan service so that
+// another pod can try it. The supervisor does not take a camera that it gave
+// back again until its abandon cooldown expires since the orphan service may
+// deliver it right back.
+// The supervisor is owned by the agents manager loop which must pass the
+// `Exits` and `Restarts` streams to `exited` and `restart`. It is not safe for concurrent use.
+type supervisor struct {
+	CanxCtx     context.Context
+	Svcs        pipeline.ServicesFactory
+	Streamers   []pipeline.Streamer
+	ErrorStream chan interface{}
+	StatsStream chan interface{}
+	AlertStream chan pipeline.AlertData
+	Stats       *model.AgentsManagerStats
+	Agents      map[string]*agent
+	Exits       chan agentExit
+	Restarts    chan agentRestart
+	Generation  int64
+	// Abandoned cameras -> end of their cooldown
+	Abandoned map[string]time.Time
+}
+
+func newSupervisor(canxCtx context.Context,
+	svcs pipeline.ServicesFactory,
+	streamers []pipeline.Streamer,
+	errorStream chan interface{},
+	statsStream chan interface{},
+	alertStream chan pipeline.AlertData,
+	stats *model.AgentsManagerStats) *supervisor {
+	return &supervisor{
+		CanxCtx:     canxCtx,
+		Svcs:        svcs,
+		Streamers:   streamers,
+		ErrorStream: errorStream,
+		StatsStream: statsStream,
+		AlertStream: alertStream,
+		Stats:       stats,
+		Agents:      map[string]*agent{},
+		Exits:       make(chan agentExit),
+		Restarts:    make(chan agentRestart),
+		Abandoned:   map[string]time.Time{},
+	}
+}
+
+// start runs an agent for the camera. Failures carries over the failures
+// of the previous runs when the agent is restarted.
+func (s *supervisor) start(camera model.Camera, failures int) {
+	// Create a child context for the agent
+	// to allow us to cancel an agent
+	// without cancelling the main context
+	agentCanxCtx, agentCanxFn := context.WithCancel(s.CanxCtx)
+
+	s.Generation++
+	a := &agent{
+		Camera:     camera,
+		ID:         uuid.NewString(),
+		CanxFn:     agentCanxFn,
+		Done:       make(chan struct{}),
+		Generation: s.Generation,
+		StartTime:  time.Now(),
+		Failures:   failures,
+	}
+
+	go func() {
+		err := pipeline.Agent(agentCanxCtx, s.Svcs, s.ErrorStream, s.StatsStream, s.AlertStream, camera, a.ID, s.Streamers)
+		close(a.Done)
+
+		// Nobody listens once the manager is shutting down
+		select {
+		case s.Exits <- agentExit{CameraID: camera.ID, Generation: a.Generation, Err: err}:
+		case <-s.CanxCtx.Done():
+		}
+	}()
+
+	// Store the agent in memory
+	s.Agents[camera.ID] = a
+}
+
+// stop cancels an agent and waits for it to release its camera.
+// Streams are drained while waiting since the agent may be blocked on them.
+func (s *supervisor) stop(id string) {
+	a, ok := s.Agents[id]
+	if !ok {
+		return
+	}
+
+	a.CanxFn()
+	delete(s.Agents, id)
+
+	timer := time.NewTimer(agentStopTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-a.Done:
+			return
+
+		case <-timer.C:
+			lgr.Logger.Warn(
+				"agent did not stop in time",
+				slog.String("cameraID", id),
+			)
+			return
+
+		case st := <-s.StatsStream:
+			procStats(s.Svcs.DataSvc, st)
+
+		case e := <-s.ErrorStream:
+			procError(s.Svcs.DataSvc, e)
+		}
+	}
+}
+
+// exited decides what to do with an agent that exited on its own
+func (s *supervisor) exited(exit agentExit) {
+	a, ok := s.Agents[exit.CameraID]
+	if !ok || a.Generation != exit.Generation {
+		// The agent was stopped on purpose
+		return
+	}
+
+	// Release the agent context
+	a.CanxFn()
+
+	if exit.Err == nil {
+		delete(s.Agents, exit.CameraID)
+		return
+	}
+
+	// A finite source (i.e. a replay) was played to the end so there is nothing to restart
+	if errors.Is(exit.Err, pipeline.ErrSourceEnded) {
+		s.ended(a)
+		return
+	}
+
+	procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+		exit.Err,
+		map[string]interface{}{},
+		"agent for camera %s exited",
+		a.Camera.Name))
+
+	// Another agent owns the camera so there is nothing to restart
+	if errors.Is(exit.Err, pipeline.ErrCameraLost) || errors.Is(exit.Err, data.ErrCameraClaimed) {
+		delete(s.Agents, exit.CameraID)
+		return
+	}
+
+	s.Stats.TotalAgentFailures++
+	if time.Since(a.StartTime) >= agentHealthyRuntime {
+		a.Failures = 0
+	}
+	a.Failures++
+
+	if a.Failures > s.Svcs.CfgSvc.GetAgentMaxRestarts() {
+		s.abandon(a)
+		return
+	}
+
+	delay := pipeline.Backoff(a.Failures,
+		time.Duration(s.Svcs.CfgSvc.GetAgentRestartBackoff())*time.Second,
+		time.Duration(s.Svcs.CfgSvc.GetAgentRestartMaxBackoff())*time.Second)
+
+	lgr.Logger.Info(
+		"restarting agent",
+		slog.String("camera", a.Camera.Name),
+		slog.Int("failures", a.Failures),
+		slog.Duration("backoff", delay),
+	)
+
+	a.Restarting = true
+	restart := agentRestart{CameraID: exit.CameraID, Generation: a.Generation}
+	time.AfterFunc(delay, func() {
+		select {
+		case s.Restarts <- restart:
+		case <-s.CanxCtx.Done():
+		}
+	})
+}
+
+// restart runs the agent again once its backoff expired. The camera record
+// is refreshed since it may have changed, been excluded or deleted in the meantime.
+func (s *supervisor) restart(restart agentRestart) {
+	a, ok := s.Agents[restart.CameraID]
+	if !ok || a.Generation != restart.Generation || !a.Restarting {
+		// The agent was stopped while waiting
+		return
+	}
+
+	camera := a.Camera
+	record, err := s.Svcs.DataSvc.RetrieveCamerasByID(restart.CameraID)
+	if err != nil {
+		procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+			err,
+			map[string]interface{}{},
+			"error retrieving camera: %s",
+			camera.Name))
+	} else {
+		if record.ID == "" || record.Excluded {
+			delete(s.Agents, restart.CameraID)
+			return
+		}
+		camera = record
+	}
+
+	s.Stats.TotalAgentRestarts++
+	s.start(camera, a.Failures)
+}
+
+// abandon gives the camera back so that another pod can run it. The agent normally released
+// the camera when it exited but it is released again in case it could not (i.e. a data service
+// error). A released camera is orphaned right away so the monitor of any pod picks it up without
+// waiting for its lease to expire. The camera is also published to the orphan service for the
+// services that deliver cameras themselves (the timed service reads the orphaned cameras from
+// the data service and ignores it).
+func (s *supervisor) abandon(a *agent) {
+	delete(s.Agents, a.Camera.ID)
+	s.Abandoned[a.Camera.ID] = time.Now().Add(time.Duration(s.Svcs.CfgSvc.GetAgentAbandonCooldown()) * time.Second)
+	s.Stats.TotalAbandonedCameras++
+
+	lgr.Logger.Warn(
+		"agent failed too many times. Giving the camera back",
+		slog.String("camera", a.Camera.Name),
+		slog.Int("failures", a.Failures),
+	)
+
+	err := s.Svcs.DataSvc.ReleaseCamera(a.Camera.ID, a.ID)
+	if err != nil && !errors.Is(err, data.ErrCameraNotOwned) && !errors.Is(err, data.ErrCameraNotFound) {
+		procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+			err,
+			map[string]interface{}{},
+			"error releasing abandoned camera: %s",
+			a.Camera.Name))
+	}
+
+	err = s.Svcs.OrphanSvc.Publish([]model.Camera{a.Camera})
+	if err != nil {
+		procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+			err,
+			map[string]interface{}{},
+			"error giving camera %s back to the orphan service",
+			a.Camera.Name))
+	}
+
+	procEvent(s.Svcs.DataSvc, model.CameraEvent{
+		CameraID: a.Camera.ID,
+		Camera:   a.Camera.Name,
+		Type:     model.CameraEventAbandoned,
+		Message:  "camera given back after its agent failed too many times",
+	})
+}
+
+// ended excludes the camera whose source ended so that it is not played again once it is
+// orphaned. Including the camera again plays it again.
+func (s *supervisor) ended(a *agent) {
+	delete(s.Agents, a.Camera.ID)
+
+	lgr.Logger.Info(
+		"camera source ended. Excluding the camera",
+		slog.String("camera", a.Camera.Name),
+	)
+
+	err := s.Svcs.DataSvc.UpdateCameraExcluded(a.Camera.ID, true)
+	if err != nil && !errors.Is(err, data.ErrCameraNotFound) {
+		procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+			err,
+			map[string]interface{}{},
+			"error excluding camera %s whose source ended",
+			a.Camera.Name))
+	}
+
+	procEvent(s.Svcs.DataSvc, model.CameraEvent{
+		CameraID: a.Camera.ID,
+		Camera:   a.Camera.Name,
+		Type:     model.CameraEventEnded,
+		Message:  "camera excluded after its source ended",
+	})
+}
+
+// adopt starts the agents of the orphaned cameras and returns the cameras that the pod cannot accommodate
+func (s *supervisor) adopt(cameras []model.Camera) []model.Camera {
+	unAccomodatedCameras := []model.Camera{}
+
+	// Run each camera's agent using configured streamers
+	for _, camera := range cameras {
+		// We already run (or are restarting) this camera's agent
+		if _, ok := s.Agents[camera.ID]; ok {
+			continue
+		}
+
+		// We gave the camera back so that another pod can try it
+		if s.cooling(camera.ID) {
+			continue
+		}
+
+		if len(s.Agents) >= s.Svcs.CfgSvc.GetMaxAgentsPerPod() {
+			unAccomodatedCameras = append(unAccomodatedCameras, camera)
+			continue
+		}
+
+		// Refresh the camera record so that the agent pipeline is built
+		// from the camera's latest streamers and parameter overrides
+		record, err := s.Svcs.DataSvc.RetrieveCamerasByID(camera.ID)
+		if err != nil {
+			procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+				err,
+				map[string]interface{}{},
+				"error retrieving camera: %s",
+				camera.Name))
+			continue
+		}
+
+		if record.ID != "" {
+			camera = record
+		}
+
+		// The camera may have been excluded since it was published
+		if camera.Excluded {
+			continue
+		}
+
+		s.start(camera, 0)
+	}
+
+	return unAccomodatedCameras
+}
+
+// check refreshes the cameras of the running agents. It stops the agents of the excluded
+// and deleted cameras and restarts the agents whose pipeline definition changed.
+func (s *supervisor) check() {
+	runningAgentIDs := make([]string, 0, len(s.Agents))
+	for id := range s.Agents {
+		runningAgentIDs = append(runningAgentIDs, id)
+	}
+
+	// Retrieve cameras from the data service
+	cameras, err := s.Svcs.DataSvc.RetrieveCamerasByIDs(runningAgentIDs)
+	if err != nil {
+		procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+			err,
+			map[string]interface{}{},
+			"error retrieving cameras by IDs from the data service"))
+		return
+	}
+
+	// Stop the agents of deleted cameras
+	found := map[string]bool{}
+	for _, camera := range cameras {
+		found[camera.ID] = true
+	}
+
+	for _, id := range runningAgentIDs {
+		if !found[id] {
+			lgr.Logger.Info(
+				"camera was deleted. Stopping its agent",
+				slog.String("cameraID", id),
+			)
+			s.stop(id)
+		}
+	}
+
+	// I think it is better to centralize the logic in the agents manager
+	// as opposed to having each agent monitor its own commands
+	// Go through the running agents and see if they can be excluded
+	for _, camera := range cameras {
+		if camera.Excluded {
+			lgr.Logger.Debug(
+				"camera is in exclusion list. Stopping its agent",
+				slog.String("cameraID", camera.ID),
+			)
+			s.exclude(camera)
+			continue
+		}
+
+		// Restart the agent if its pipeline definition changed (i.e. a new RTSP URL)
+		running, ok := s.Agents[camera.ID]
+		if ok && data.PipelineChanged(running.Camera, camera) {
+			lgr.Logger.Info(
+				"camera definition changed. Restarting its agent",
+				slog.String("cameraID", camera.ID),
+				slog.String("rtsp", camera.RtspURL),
+			)
+			s.stop(camera.ID)
+			s.start(camera, 0)
+		}
+	}
+}
+
+// exclude stops the agent of an excluded camera, makes sure that the camera is released and
+// records an excluded camera event. Excluded cameras are not orphaned so the monitor leaves the
+// camera alone until it is included again.
+func (s *supervisor) exclude(camera model.Camera) {
+	s.stop(camera.ID)
+
+	// The agent releases the camera when it stops. This only applies if it did not
+	// (i.e. it did not stop in time) and the camera is still owned by the same agent.
+	err := s.Svcs.DataSvc.ReleaseCamera(camera.ID, camera.AgentID)
+	if err != nil && !errors.Is(err, data.ErrCameraNotOwned) && !errors.Is(err, data.ErrCameraNotFound) {
+		procError(s.Svcs.DataSvc, model.GenError("agents_manager",
+			err,
+			map[string]interface{}{},
+			"error releasing excluded camera: %s",
+			camera.Name))
+	}
+
+	procEvent(s.Svcs.DataSvc, model.CameraEvent{
+		CameraID: camera.ID,
+		Camera:   camera.Name,
+		Type:     model.CameraEventExcluded,
+		Message:  "agent stopped since the camera was excluded",
+	})
+}
+
+// cooling tells whether the camera was abandoned and its cooldown did not expire yet
+func (s *supervisor) cooling(id string) bool {
+	until, ok := s.Abandoned[id]
+	if !ok {
+		return false
+	}
+
+	if time.Now().Before(until) {
+		return true
+	}
+
+	delete(s.Abandoned, id)
+	return false
+}
@@ -0,0 +1,201 @@
+package mode
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/pipeline"
+)
+
+// nextExit waits for an agent to exit and lets the supervisor handle it
+func nextExit(t *testing.T, sup *supervisor) agentExit {
+	t.Helper()
+
+	select {
+	case exit := <-sup.Exits:
+		sup.exited(exit)
+		return exit
+	case <-time.After(5 * time.Second):
+		t.Fatalf("agent did not exit")
+	}
+	return agentExit{}
+}
+
+// nextRestart waits for the backoff of an agent to expire and lets the supervisor restart it
+func nextRestart(t *testing.T, sup *supervisor) {
+	t.Helper()
+
+	select {
+	case restart := <-sup.Restarts:
+		sup.restart(restart)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("agent was not restarted")
+	}
+}
+
+func TestFailedAgentIsRestartedThenAbandoned(t *testing.T) {
+	// The agent of a camera with an unknown framer fails right away
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "front", FramerType: "unknown"})
+	sup, orphanSvc := newTestSupervisor(t, dataSvc)
+	maxRestarts := sup.Svcs.CfgSvc.GetAgentMaxRestarts()
+
+	sup.adopt([]model.Camera{{ID: "1"}})
+
+	for failures := 1; failures <= maxRestarts; failures++ {
+		exit := nextExit(t, sup)
+		if exit.Err == nil {
+			t.Fatalf("agent exited without an error")
+		}
+
+		// The agent keeps its slot while it waits for its backoff
+		a, ok := sup.Agents["1"]
+		if !ok || !a.Restarting || a.Failures != failures {
+			t.Fatalf("failure %d: agent is not restarting: %+v", failures, a)
+		}
+
+		nextRestart(t, sup)
+		if a := sup.Agents["1"]; a.Restarting || a.Failures != failures {
+			t.Fatalf("failure %d: agent was not restarted: %+v", failures, a)
+		}
+	}
+
+	// One failure too many gives the camera back
+	nextExit(t, sup)
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("abandoned camera agent is still running")
+	}
+	if len(orphanSvc.Published) != 1 || orphanSvc.Published[0].ID != "1" {
+		t.Fatalf("camera was not given back: %+v", orphanSvc.Published)
+	}
+	if len(dataSvc.Events) != 1 || dataSvc.Events[0].Type != model.CameraEventAbandoned {
+		t.Fatalf("abandoned event was not recorded: %+v", dataSvc.Events)
+	}
+
+	stats := sup.Stats
+	if stats.TotalAgentFailures != int64(maxRestarts+1) || stats.TotalAgentRestarts != int64(maxRestarts) || stats.TotalAbandonedCameras != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAbandonedCameraIsNotAdoptedDuringCooldown(t *testing.T) {
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "front", FramerType: "unknown"})
+	sup, _ := newTestSupervisor(t, dataSvc)
+
+	sup.adopt([]model.Camera{{ID: "1"}})
+	for i := 0; i < sup.Svcs.CfgSvc.GetAgentMaxRestarts(); i++ {
+		nextExit(t, sup)
+		nextRestart(t, sup)
+	}
+	nextExit(t, sup)
+
+	// The orphan service delivers the camera right back
+	sup.adopt([]model.Camera{{ID: "1"}})
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("abandoned camera was adopted during its cooldown")
+	}
+
+	// Once the cooldown expires, the camera starts over with no failures
+	sup.Abandoned["1"] = time.Now()
+	sup.adopt([]model.Camera{{ID: "1"}})
+	a, ok := sup.Agents["1"]
+	if !ok || a.Failures != 0 {
+		t.Fatalf("camera was not adopted after its cooldown: %+v", a)
+	}
+	if _, ok := sup.Abandoned["1"]; ok {
+		t.Fatalf("expired cooldown was kept")
+	}
+}
+
+func TestAbandonedCameraIsReleased(t *testing.T) {
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "front"})
+	sup, _ := newTestSupervisor(t, dataSvc)
+
+	sup.adopt([]model.Camera{{ID: "1"}})
+	a := sup.Agents["1"]
+	waitFor(t, "the camera to be claimed by the agent", func() bool {
+		return dataSvc.camera("1").AgentID == a.ID
+	})
+
+	// The agent could not release the camera itself so the supervisor does
+	sup.abandon(a)
+	if !dataSvc.released("1") || dataSvc.camera("1").AgentID != "" {
+		t.Fatalf("abandoned camera was not released: %+v", dataSvc.camera("1"))
+	}
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("abandoned camera agent was kept")
+	}
+}
+
+func TestLostCameraAgentIsNotRestarted(t *testing.T) {
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "front"})
+	sup, orphanSvc := newTestSupervisor(t, dataSvc)
+
+	sup.adopt([]model.Camera{{ID: "1"}})
+	a := sup.Agents["1"]
+
+	// Another agent took over the camera
+	sup.exited(agentExit{CameraID: "1", Generation: a.Generation, Err: pipeline.ErrCameraLost})
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("lost camera agent was kept")
+	}
+	if sup.Stats.TotalAgentFailures != 0 || len(orphanSvc.Published) != 0 {
+		t.Fatalf("lost camera was counted as a failure: %+v", sup.Stats)
+	}
+}
+
+func TestStaleExitsAndRestartsAreIgnored(t *testing.T) {
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "front"})
+	sup, _ := newTestSupervisor(t, dataSvc)
+
+	sup.adopt([]model.Camera{{ID: "1"}})
+	a := sup.Agents["1"]
+
+	// Events of a previous run of the camera do not affect the current one
+	sup.exited(agentExit{CameraID: "1", Generation: a.Generation - 1, Err: errors.New("failed")})
+	sup.restart(agentRestart{CameraID: "1", Generation: a.Generation - 1})
+	if sup.Agents["1"] != a || a.Restarting || a.Failures != 0 {
+		t.Fatalf("stale events changed the agent: %+v", sup.Agents["1"])
+	}
+
+	// A restart for an agent that is not waiting for its backoff is ignored
+	sup.restart(agentRestart{CameraID: "1", Generation: a.Generation})
+	if sup.Agents["1"] != a || sup.Stats.TotalAgentRestarts != 0 {
+		t.Fatalf("running agent was restarted")
+	}
+}
+
+func TestEndedCameraIsExcluded(t *testing.T) {
+	dataSvc := newFakeData(model.Camera{ID: "1", Name: "replay", FramerType: finiteFramerName})
+	sup, orphanSvc := newTestSupervisor(t, dataSvc)
+
+	sup.adopt([]model.Camera{{ID: "1"}})
+	exit := nextExit(t, sup)
+	if !errors.Is(exit.Err, pipeline.ErrSourceEnded) {
+		t.Fatalf("agent did not report the end of its source: %v", exit.Err)
+	}
+
+	// The agent is not restarted nor given back and it released the camera
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("ended camera agent was kept")
+	}
+	if sup.Stats.TotalAgentFailures != 0 || len(orphanSvc.Published) != 0 {
+		t.Fatalf("ended camera was counted as a failure: %+v", sup.Stats)
+	}
+	if !dataSvc.released("1") {
+		t.Fatalf("ended camera was not released")
+	}
+
+	// The camera is excluded so that it is not played again
+	if !dataSvc.camera("1").Excluded {
+		t.Fatalf("ended camera was not excluded")
+	}
+	if len(dataSvc.Events) != 1 || dataSvc.Events[0].Type != model.CameraEventEnded {
+		t.Fatalf("ended event was not recorded: %+v", dataSvc.Events)
+	}
+	sup.adopt([]model.Camera{{ID: "1"}})
+	if _, ok := sup.Agents["1"]; ok {
+		t.Fatalf("ended camera was adopted again")
+	}
+}
@@ -27,6 +27,8 @@ func procStats(datasvc data.IService, stats interface{}) {
 		procStreamerStats(datasvc, stats)
 	case model.AlerterStats:
 		procAlerterStats(datasvc, stats)
+	case model.CountStats:
+		procCountStats(datasvc, stats)
 	default:
 		lgr.Logger.Error(
 			"unknown stats type",
@@ -90,6 +92,28 @@ func procAlerterStats(datasvc data.IService, stats model.AlerterStats) {
 	}
 }
 
+func procCountStats(datasvc data.IService, stats model.CountStats) {
+	err := datasvc.NewCountStats(stats)
+	if err != nil {
+		lgr.Logger.Error(
+			"failed to store count stats",
+			slog.Any("stats", stats),
+			slog.Any("error", err),
+		)
+	}
+}
+
+func procEvent(datasvc data.IService, event model.CameraEvent) {
+	err := datasvc.NewCameraEvent(event)
+	if err != nil {
+		lgr.Logger.Error(
+			"failed to store camera event",
+			slog.Any("event", event),
+			slog.Any("error", err),
+		)
+	}
+}
+
 func procError(datasvc data.IService, err interface{}) {
 	errTemp := datasvc.NewError(err)
 	if errTemp != nil {
@@ -23,6 +23,16 @@ func GenError(proc string, err error, misc map[string]interface{}, messagef stri
 	}
 }
 
+// ErrorRecord is the persisted form of a CustomError (or any other error)
+type ErrorRecord struct {
+	Timestamp  int64                  `json:"timestamp"`
+	Processor  string                 `json:"processor"`
+	Inner      string                 `json:"innerError"`
+	Message    string                 `json:"message"`
+	StackTrace string                 `json:"stackTrace"`
+	Misc       map[string]interface{} `json:"misc"`
+}
+
 type Camera struct {
 	ID            string `json:"id"`
 	VMSIdentifier string `json:"vmsId"`
@@ -34,6 +44,71 @@ type Camera struct {
 	StartupTime   int64  `json:"startupTime"`   // The startup time of the agent
 	LastHeartBeat int64  `json:"lastHeartbeat"` // The last heartbeat time of the agent
 	Uptime        int64  `json:"uptime"`        // The uptime of the agent
+	LeaseExpiry   int64  `json:"leaseExpiry"`   // The time at which the agent lease expires unless renewed
+
+	Streamers          []string                          `json:"streamers,omitempty"`          // The streamers to run for this camera. If empty, the pod streamers are used
+	StreamerParameters map[string]map[string]interface{} `json:"streamerParameters,omitempty"` // Per-streamer parameter overrides keyed by streamer name
+	Zones              []Zone                            `json:"zones,omitempty"`              // If any, detection alerts only fire when an object enters one of them
+	Lines              []Line                            `json:"lines,omitempty"`              // Lines whose crossings are counted by the line counter
+}
+
+// Zone triggers i.e. which part of a detected object must be inside a zone
+const (
+	ZoneTriggerFootPoint = "footPoint" // The bottom center of the object box (the default)
+	ZoneTriggerBox       = "box"       // Any part of the object box
+)
+
+// Zone is a named polygon of the camera view (i.e. "driveway").
+// Points are normalized to the frame size (0 to 1) so that zones do not depend on the stream resolution.
+type Zone struct {
+	Name    string      `json:"name"`
+	Points  []ZonePoint `json:"points"`
+	Trigger string      `json:"trigger,omitempty"`
+}
+
+type ZonePoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Line is a named virtual line of the camera view (i.e. "entrance"). Like zone points, its ends are normalized.
+// Objects that cross it to the right (when looking from `From` to `To`) are counted in and the others out.
+type Line struct {
+	Name string    `json:"name"`
+	From ZonePoint `json:"from"`
+	To   ZonePoint `json:"to"`
+}
+
+// CameraChange is an audit trail entry of a camera definition change
+type CameraChange struct {
+	Timestamp int64   `json:"timestamp"`
+	CameraID  string  `json:"cameraId"`
+	Action    string  `json:"action"` // create, update, delete or exclude
+	Before    *Camera `json:"before,omitempty"`
+	After     *Camera `json:"after,omitempty"`
+}
+
+// Camera event types
+const (
+	// The agents manager gave the camera back after its agent failed too many times
+	CameraEventAbandoned = "abandoned"
+	// The agents manager excluded the camera after its source (i.e. a replay) ended
+	CameraEventEnded = "ended"
+	// The agents manager stopped the agent of a camera that was excluded
+	CameraEventExcluded = "excluded"
+	// The framer lost the camera stream
+	CameraEventOffline = "offline"
+	// The framer receives frames again after the camera was offline
+	CameraEventOnline = "online"
+)
+
+// CameraEvent records something that happened to a running camera
+type CameraEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	CameraID  string `json:"cameraId"`
+	Camera    string `json:"camera"`
+	Type      string `json:"type"`
+	Message   string `json:"message"`
 }
 
 type AlerterStats struct {
@@ -53,7 +128,23 @@ type StreamerStats struct {
 	Errors      int     `json:"errors"`
 	Uptime      int64   `json:"uptime"`
 	AvgProcTime float64 `json:"avgProcTime"`
-	Timestamp   int64   `json:"timestamp"`
+	// Input stream counters (see the streamer backpressure policy). Streamers with
+	// several workers report them with worker 0 since the workers share the stream.
+	DroppedFrames int     `json:"droppedFrames"`
+	AvgQueueDepth float64 `json:"avgQueueDepth"`
+	MaxQueueDepth int     `json:"maxQueueDepth"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// CountStats are the crossings of a camera line counted by a streamer over a period
+type CountStats struct {
+	Name      string `json:"name"`   // Streamer name
+	Camera    string `json:"camera"` // Camera name
+	Line      string `json:"line"`
+	In        int    `json:"in"`
+	Out       int    `json:"out"`
+	Period    int64  `json:"period"` // Seconds covered by the counts
+	Timestamp int64  `json:"timestamp"`
 }
 
 type FramerStats struct {
@@ -81,5 +172,20 @@ type AgentsManagerStats struct {
 	TotalRunningAgents                  int64   `json:"runningAgents"`
 	TotalRunningAgentsUptime            int64   `json:"runningAgentsUptime"`
 	AvgRunningAgentsPerMin              float64 `json:"avgRunningAgentsPerMin"`
+	TotalAgentFailures                  int64   `json:"agentFailures"`    // Agents that exited unexpectedly
+	TotalAgentRestarts                  int64   `json:"agentRestarts"`    // Agents restarted by the supervisor
+	TotalAbandonedCameras               int64   `json:"abandonedCameras"` // Cameras given back after too many failures
 	Timestamp                           int64   `json:"timestamp"`
 }
+
+// StatsSummary aggregates framer or streamer stats over a query
+type StatsSummary struct {
+	Records     int     `json:"records"`     // Number of stats records aggregated
+	Frames      int64   `json:"frames"`      // Total frames
+	Errors      int64   `json:"errors"`      // Total errors
+	AvgFPS      float64 `json:"avgFps"`      // Average of the reported FPS
+	AvgProcTime float64 `json:"avgProcTime"` // Average processing time (streamers only)
+	ErrorRate   float64 `json:"errorRate"`   // Errors per frame
+	From        int64   `json:"from"`        // Timestamp of the oldest record
+	To          int64   `json:"to"`          // Timestamp of the newest record
+}
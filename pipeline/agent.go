@@ -2,23 +2,48 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"github.com/khaledhikmat/vs-go/service/data"
 	"github.com/khaledhikmat/vs-go/service/lgr"
 )
 
+// ErrCameraLost is returned by the agent when it stops because another agent took over its camera
+var ErrCameraLost = errors.New("agent lost the camera ownership")
+
 func Agent(canxCtx context.Context,
 	svcs ServicesFactory,
 	errorStream chan interface{},
 	statsStream chan interface{},
 	alertStream chan AlertData,
 	camera model.Camera,
+	agentID string,
 	streamers []Streamer) error {
-	agentID := uuid.NewString()
+	// The camera record may carry its own streamers, otherwise the pod streamers are used
+	streamers, err := resolveStreamers(camera, streamers)
+	if err != nil {
+		return err
+	}
+
+	// Unknown framer types are errors rather than silently treated as RTSP
+	framer, err := resolveFramer(camera)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the camera parameter overrides are valid before we start
+	for name, overrides := range camera.StreamerParameters {
+		_, err := config.OverrideStreamerParameters(name, svcs.CfgSvc.GetStreamerParameters(name), overrides)
+		if err != nil {
+			return fmt.Errorf("error applying camera %s streamer parameters: %w", camera.Name, err)
+		}
+	}
+
 	lgr.Logger.Info(
 		"agent starting....",
 		slog.String("agentID", agentID),
@@ -36,37 +61,94 @@ func Agent(canxCtx context.Context,
 		Uptime: agentStartTime,
 	}
 
-	// Update the camera agent id
-	err := svcs.DataSvc.UpdateCameraAgentID(camera.ID, agentID)
+	// Claim the camera so that no other agent can run it while we hold the lease
+	// If another agent holds the lease, refuse to start
+	err = svcs.DataSvc.ClaimCamera(camera.ID, agentID, svcs.CfgSvc.GetAgentLeaseDuration())
 	if err != nil {
-		return fmt.Errorf("error updating camera agent id: %w", err)
+		return fmt.Errorf("error claiming camera %s: %w", camera.Name, err)
 	}
 
-	// Setup the stream channels
-	streamChannels := []chan FrameData{}
+	// The pipeline runs in its own context so that the agent can stop it
+	// if it loses the camera ownership
+	pipelineCtx, pipelineCancel := context.WithCancel(canxCtx)
+	defer pipelineCancel()
+
+	// Setup the streams
+	streams := []*Stream{}
 	for _, streamer := range streamers {
-		streamChannels = append(streamChannels, streamer(canxCtx, svcs, camera, errorStream, statsStream, alertStream))
+		streams = append(streams, streamer(pipelineCtx, svcs, camera, errorStream, statsStream, alertStream))
 	}
 
-	// Start the agent frame capturer
-	framer(canxCtx, svcs, camera, errorStream, statsStream, streamChannels)
+	// Start the agent frame capturer. The streams are closed once it no longer sends.
+	framerDone := make(chan error, 1)
+	go func() {
+		err := framer(pipelineCtx, svcs, camera, errorStream, statsStream, alertStream, streams)
+		closeStreams(streams)
+		framerDone <- err
+	}()
+
+	// Release the camera so that it can be picked up right away
+	// instead of waiting for the lease to expire
+	release := func() {
+		err := svcs.DataSvc.ReleaseCamera(camera.ID, agentID)
+		if err != nil && !errors.Is(err, data.ErrCameraNotOwned) {
+			lgr.Logger.Error(
+				"error releasing camera",
+				slog.String("camera", camera.Name),
+				slog.Any("error", err),
+			)
+		}
+	}
 
-	// Monitor cancellations and update heartbeats
+	// Monitor cancellations and renew the lease
 	for {
 		select {
 		case <-canxCtx.Done():
 			lgr.Logger.Info(
 				"agent context cancelled",
 			)
+
+			release()
 			return nil
 
-		case <-time.After(time.Duration(time.Duration(svcs.CfgSvc.GetAgentsManagerPeriodicTimeout()) * time.Second)):
-			// Update the agent heartbeat so that the agents monitor would know
+		case err := <-framerDone:
+			release()
+
+			// The framer also returns when the agent is cancelled
+			if canxCtx.Err() != nil {
+				return nil
+			}
+
+			// Otherwise its source ended (i.e. a replay) or failed
+			lgr.Logger.Info(
+				"agent framer stopped. Stopping",
+				slog.String("agentID", agentID),
+				slog.String("camera", camera.Name),
+				slog.Any("error", err),
+			)
+			if err == nil {
+				err = ErrSourceEnded
+			}
+			return fmt.Errorf("camera %s framer: %w", camera.Name, err)
+
+		case <-time.After(time.Duration(time.Duration(svcs.CfgSvc.GetAgentPeriodicTimeout()) * time.Second)):
+			// Renew the agent lease so that the agents monitor would know
 			// that the agent is alive and kicking and does need to be re-scheduled
-			err := svcs.DataSvc.UpdateCameraAgentHeartbeat(camera.ID)
+			err := svcs.DataSvc.RenewLease(camera.ID, agentID, svcs.CfgSvc.GetAgentLeaseDuration())
+			if errors.Is(err, data.ErrCameraNotOwned) || errors.Is(err, data.ErrCameraNotFound) {
+				// Another agent took over the camera (i.e. our lease expired) so we must stop
+				lgr.Logger.Warn(
+					"agent lost the camera ownership. Stopping",
+					slog.String("agentID", agentID),
+					slog.String("camera", camera.Name),
+				)
+				return fmt.Errorf("camera %s: %w", camera.Name, ErrCameraLost)
+			}
+
 			if err != nil {
+				// Transient errors are retried on the next period as long as the lease holds
 				lgr.Logger.Error(
-					"error updating camera agent heartbeat",
+					"error renewing camera agent lease",
 					slog.Any("error", err),
 				)
 			}
@@ -78,3 +160,35 @@ func Agent(canxCtx context.Context,
 		}
 	}
 }
+
+func resolveStreamers(camera model.Camera, defaultStreamers []Streamer) ([]Streamer, error) {
+	if len(camera.Streamers) == 0 {
+		return defaultStreamers, nil
+	}
+
+	streamers := []Streamer{}
+	for _, name := range camera.Streamers {
+		streamer, err := LookupStreamer(name)
+		if err != nil {
+			return nil, fmt.Errorf("camera %s streamers: %w", camera.Name, err)
+		}
+		streamers = append(streamers, streamer)
+	}
+
+	return streamers, nil
+}
+
+// Cameras with no framer type are RTSP cameras
+func resolveFramer(camera model.Camera) (Framer, error) {
+	framerType := camera.FramerType
+	if framerType == "" {
+		framerType = config.RTSPFramerName
+	}
+
+	framer, err := LookupFramer(framerType)
+	if err != nil {
+		return nil, fmt.Errorf("camera %s framer: %w", camera.Name, err)
+	}
+
+	return framer, nil
+}
@@ -66,7 +66,8 @@ func SimpleAlerter(canx context.Context, svcs ServicesFactory, errorStream chan
 				alertImageURL := alert.FrameURL
 				alertClipURL := alert.ClipURL
 				// It is possible that the alert image and video URLs are already poupulated
-				if alertClipURL == "" {
+				// Camera events carry no frame to store
+				if alertClipURL == "" && alert.Event == "" {
 					var err error
 					fn := fmt.Sprintf("%s/%s_alerted_frame_%d.jpg", svcs.CfgSvc.GetRecordingsFolder(), alert.Camera.ID, time.Now().Unix())
 					// Store the alerted frame as an image
@@ -103,8 +104,27 @@ func SimpleAlerter(canx context.Context, svcs ServicesFactory, errorStream chan
 					"alertClipURL":  alertClipURL,
 					"label":         alert.Label,
 					"confidence":    alert.Confidence,
+					"event":         alert.Event,
 					"timestamp":     time.Now().Format(time.RFC3339),
 				}
+				if len(alert.Boxes) > 0 {
+					payload["boxes"] = alert.Boxes
+				}
+				if alert.TrackID > 0 {
+					payload["trackId"] = alert.TrackID
+					payload["trajectory"] = alert.Trajectory
+				}
+				if alert.Zone != "" {
+					payload["zone"] = alert.Zone
+				}
+				if alert.Line != "" {
+					payload["line"] = alert.Line
+					payload["direction"] = alert.Direction
+					payload["count"] = alert.Count
+				}
+				if alert.Dwell > 0 {
+					payload["dwell"] = alert.Dwell.Seconds()
+				}
 				lgr.Logger.Info(
 					"alert payload",
 					slog.Any("payload", payload),
@@ -126,3 +146,48 @@ func SimpleAlerter(canx context.Context, svcs ServicesFactory, errorStream chan
 
 	return in
 }
+
+// trySendAlert queues the alert unless the alert stream is full (i.e. the alerter is slow)
+// in which case the alert and its frame are dropped rather than slowing the streamer down
+func trySendAlert(alertStream chan AlertData, alert AlertData) {
+	select {
+	case alertStream <- alert:
+	default:
+		alert.Mat.Close()
+		lgr.Logger.Warn(
+			"alertStream full, dropping alert",
+			slog.String("camera", alert.Camera.Name),
+			slog.String("label", alert.Label),
+		)
+	}
+}
+
+// latestFrame keeps the latest frame of a streamer that does not alert on the frames
+// themselves (i.e. it alerts on the camera tracks) so that its alerts carry a frame
+type latestFrame struct {
+	frame *Frame
+}
+
+// set keeps the frame (taking over its reference) instead of the previous one
+func (l *latestFrame) set(frame *Frame) {
+	if l.frame != nil {
+		l.frame.Release()
+	}
+	l.frame = frame
+}
+
+// release drops the kept frame
+func (l *latestFrame) release() {
+	l.set(nil)
+}
+
+// alert sends the alert with a copy of the latest frame. The alert is dropped if no frame arrived yet.
+func (l *latestFrame) alert(alertStream chan AlertData, alert AlertData) {
+	if l.frame == nil {
+		lgr.Logger.Warn("no frame to alert with, dropping alert", slog.String("camera", alert.Camera.Name))
+		return
+	}
+
+	alert.Mat = l.frame.Clone()
+	trySendAlert(alertStream, alert)
+}
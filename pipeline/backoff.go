@@ -0,0 +1,27 @@
+package pipeline
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the delay before the given attempt (starting at 1). The base delay
+// doubles with every attempt up to the max delay. Up to half of the delay is randomized
+// so that processors that failed together (i.e. a network outage) do not retry together.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+
+	return time.Duration(half + rand.Int63n(half+1))
+}
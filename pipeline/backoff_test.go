@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	base := 2 * time.Second
+	maxDelay := 60 * time.Second
+
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{5, 32 * time.Second},
+		{6, 60 * time.Second},
+		{20, 60 * time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			delay := Backoff(test.attempt, base, maxDelay)
+			if delay < test.delay/2 || delay > test.delay {
+				t.Fatalf("attempt %d: delay %s is not within [%s, %s]", test.attempt, delay, test.delay/2, test.delay)
+			}
+		}
+	}
+}
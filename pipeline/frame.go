@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/khaledhikmat/vs-go/service/lgr"
+	"gocv.io/x/gocv"
+)
+
+// Number of frames that are not released yet (for all cameras)
+var liveFrames atomic.Int64
+
+// Frame is a captured image shared by the streamers of a camera instead of a copy per streamer.
+// Frames are read-only: a streamer that modifies the image, or keeps it beyond the frame
+// (i.e. in an alert), must clone the Mat. Each streamer releases the frames it gets once it is
+// done with them and the Mat is closed when the last reference is released.
+// In debug mode (see `frameDebug` config) using or releasing a released frame panics and
+// frames that are garbage collected without being released are reported as leaks.
+type Frame struct {
+	mat   gocv.Mat
+	refs  atomic.Int32
+	debug bool
+	// Where the frame was created (debug mode only)
+	stack []byte
+}
+
+// newFrame wraps a captured Mat. The frame takes ownership of the Mat
+// and the caller holds the first reference.
+func newFrame(svcs ServicesFactory, mat gocv.Mat) *Frame {
+	f := &Frame{
+		mat:   mat,
+		debug: svcs.CfgSvc.GetFrameDebug(),
+	}
+	f.refs.Store(1)
+	liveFrames.Add(1)
+
+	if f.debug {
+		f.stack = debug.Stack()
+		runtime.SetFinalizer(f, leakedFrame)
+	}
+
+	return f
+}
+
+// LiveFrames returns the number of frames that are not released yet. A number that keeps
+// growing while the cameras run points to a streamer that does not release its frames.
+func LiveFrames() int64 {
+	return liveFrames.Load()
+}
+
+// Mat returns the frame image which must not be modified
+func (f *Frame) Mat() gocv.Mat {
+	if f.debug && f.refs.Load() <= 0 {
+		panic(fmt.Sprintf("frame used after release. Created at:\n%s", f.stack))
+	}
+
+	return f.mat
+}
+
+// Clone returns a copy of the frame image that the caller owns and may modify
+func (f *Frame) Clone() gocv.Mat {
+	mat := f.Mat()
+	return mat.Clone()
+}
+
+// Retain adds a reference to the frame which must be released separately
+func (f *Frame) Retain() *Frame {
+	if f.refs.Add(1) <= 1 && f.debug {
+		panic(fmt.Sprintf("frame retained after release. Created at:\n%s", f.stack))
+	}
+
+	return f
+}
+
+// Release drops a reference to the frame and closes the Mat with the last one
+func (f *Frame) Release() {
+	refs := f.refs.Add(-1)
+	if refs == 0 {
+		f.mat.Close()
+		liveFrames.Add(-1)
+		if f.debug {
+			runtime.SetFinalizer(f, nil)
+		}
+		return
+	}
+
+	if refs < 0 && f.debug {
+		panic(fmt.Sprintf("frame released too many times. Created at:\n%s", f.stack))
+	}
+}
+
+// leakedFrame reports and closes a frame that was garbage collected without being released
+func leakedFrame(f *Frame) {
+	refs := f.refs.Load()
+	if refs <= 0 {
+		return
+	}
+
+	lgr.Logger.Error(
+		"frame leaked: garbage collected before being released",
+		slog.Int("refs", int(refs)),
+		slog.String("created", string(f.stack)),
+	)
+
+	f.mat.Close()
+	liveFrames.Add(-1)
+}
@@ -2,6 +2,9 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/khaledhikmat/vs-go/model"
@@ -9,26 +12,26 @@ import (
 	"gocv.io/x/gocv"
 )
 
-func framer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, streamChannels []chan FrameData) {
-	if camera.FramerType == "random" {
-		go randomFramer(canxCtx, svcs, camera, errorStream, statsStream, streamChannels)
-		return
-	}
+// The RTSP framer reports these to the error stream when the camera goes offline and back online
+var (
+	ErrCameraOffline = errors.New("camera is offline")
+	ErrCameraOnline  = errors.New("camera is back online")
+)
 
-	go rtspFramer(canxCtx, svcs, camera, errorStream, statsStream, streamChannels)
-}
+// ErrSourceEnded is returned by the framers of finite sources once they played them
+var ErrSourceEnded = errors.New("camera source ended")
 
-func rtspFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, streamChannels []chan FrameData) {
-	webcam, err := gocv.OpenVideoCapture(camera.RtspURL)
-	if err != nil {
-		errorStream <- model.GenError("agent_rtsp_framer",
-			err,
-			map[string]interface{}{},
-			"error opening RTSP stream")
-		return
-	}
-	defer webcam.Close()
+// rtspRead is the outcome of opening the stream (Mat is not set) or of reading a frame
+type rtspRead struct {
+	Mat gocv.Mat
+	OK  bool
+	Err error
+}
 
+// rtspFramer reconnects with backoff when the stream is lost: after a number of consecutive
+// failed reads or when no frame arrives within the stall timeout. Frames are read by
+// a reader goroutine so that a read blocked on a dead stream does not block the framer.
+func rtspFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData, streams []*Stream) error {
 	var startTime = time.Now().Unix()
 	var endTime = time.Now().Unix()
 	var frames = 0
@@ -50,50 +53,198 @@ func rtspFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Came
 		}
 	}()
 
-	// Capture frames, route captured frames to streamers and monitor cancellations
+	offline := false
+	setOffline := func(cause error) {
+		if offline {
+			return
+		}
+		offline = true
+
+		lgr.Logger.Warn(
+			"camera is offline",
+			slog.String("camera", camera.Name),
+			slog.Any("cause", cause),
+		)
+		sendCameraEvent(canxCtx, errorStream, alertStream, camera, model.CameraEventOffline,
+			model.GenError("agent_rtsp_framer",
+				ErrCameraOffline,
+				map[string]interface{}{"camera": camera.Name, "cause": cause.Error()},
+				"camera %s is offline",
+				camera.Name))
+	}
+
+	setOnline := func() {
+		if !offline {
+			return
+		}
+		offline = false
+
+		lgr.Logger.Info(
+			"camera is back online",
+			slog.String("camera", camera.Name),
+		)
+		sendCameraEvent(canxCtx, errorStream, alertStream, camera, model.CameraEventOnline,
+			model.GenError("agent_rtsp_framer",
+				ErrCameraOnline,
+				map[string]interface{}{"camera": camera.Name},
+				"camera %s is back online",
+				camera.Name))
+	}
+
+	keyFrames := keyFrameClock{}
+	attempts := 0
 	for {
+		// The first frame after a (re)connect is a key frame
+		keyFrames.reset()
+		connCtx, connCancel := context.WithCancel(canxCtx)
+		reads := rtspReader(connCtx, camera.RtspURL)
+		stallTimeout := time.Duration(svcs.CfgSvc.GetFramerStallTimeout()) * time.Second
+		stall := time.NewTimer(stallTimeout)
+		failures := 0
+
+		// Capture frames, route captured frames to streamers and monitor cancellations
+	capture:
+		for {
+			select {
+			case <-canxCtx.Done():
+				lgr.Logger.Info(
+					"rtspFramer context cancelled",
+				)
+				stall.Stop()
+				connCancel()
+				return nil
+
+			case <-stall.C:
+				errors++
+				setOffline(fmt.Errorf("no frame received within %s", stallTimeout))
+				break capture
+
+			case read := <-reads:
+				if read.Err != nil {
+					errors++
+					setOffline(read.Err)
+					break capture
+				}
+
+				if !read.OK {
+					errors++
+					failures++
+					if failures >= svcs.CfgSvc.GetFramerMaxReadFailures() {
+						setOffline(fmt.Errorf("%d consecutive failed reads", failures))
+						break capture
+					}
+					continue
+				}
+
+				failures = 0
+				attempts = 0
+				stall.Reset(stallTimeout)
+				setOnline()
+
+				frames++
+				now := time.Now()
+				frame := FrameData{Frame: newFrame(svcs, read.Mat), Timestamp: now, KeyFrame: keyFrames.next(now)}
+				routed, more := routeFrame(canxCtx, streams, frame)
+				frame.Release() // Crucial to release the frame to avoid memory leaks
+				if !more {
+					// Context canceled, stop sending
+					lgr.Logger.Info("rtspFramer context cancelled while sending!!")
+					stall.Stop()
+					connCancel()
+					return nil
+				}
+
+				// No streamer sampled the frame
+				if routed == 0 {
+					skippedFrames++
+				}
+
+				// Slow streamers must not be mistaken for a stalled stream
+				stall.Reset(stallTimeout)
+			}
+		}
+
+		// Drop the connection and wait before reconnecting
+		stall.Stop()
+		connCancel()
+		attempts++
+
+		delay := Backoff(attempts,
+			time.Duration(svcs.CfgSvc.GetFramerReconnectBackoff())*time.Second,
+			time.Duration(svcs.CfgSvc.GetFramerReconnectMaxBackoff())*time.Second)
+		lgr.Logger.Info(
+			"rtspFramer reconnecting",
+			slog.String("camera", camera.Name),
+			slog.Int("attempt", attempts),
+			slog.Duration("backoff", delay),
+		)
+
 		select {
 		case <-canxCtx.Done():
 			lgr.Logger.Info(
 				"rtspFramer context cancelled",
 			)
-			return
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
 
-		default:
-			img := gocv.NewMat()
-			if ok := webcam.Read(&img); !ok || img.Empty() {
-				errors++
-				img.Close() // Crucial to close the image to avoid memory leaks
-				continue
+// rtspReader opens the stream and reads frames until cancelled. The reader owns the capture
+// and closes it when it stops which may be well after it is cancelled since a read on a dead
+// stream only returns once the stream times out.
+func rtspReader(connCtx context.Context, url string) <-chan rtspRead {
+	reads := make(chan rtspRead)
+
+	go func() {
+		webcam, err := gocv.OpenVideoCapture(url)
+		if err != nil {
+			select {
+			case reads <- rtspRead{Err: fmt.Errorf("error opening RTSP stream: %w", err)}:
+			case <-connCtx.Done():
 			}
+			return
+		}
+		defer webcam.Close()
 
-			frames++
-			// Determine if we should skip the frame
-			if svcs.InferenceSvc.CanSkipFrame(frames) {
-				skippedFrames++
+		for {
+			img := gocv.NewMat()
+			ok := webcam.Read(&img) && !img.Empty()
+			if !ok {
 				img.Close() // Crucial to close the image to avoid memory leaks
-				continue
 			}
 
-			for _, streamChan := range streamChannels {
-				// WARNING: We need an extra check to make sure we don't send on c closed channel
-				select {
-				case <-canxCtx.Done():
-					// Context canceled, stop sending
-					lgr.Logger.Info("rtspFramer context cancelled while sending!!")
-					img.Close() // Crucial to close the image to avoid memory leaks
-					return
-				case streamChan <- FrameData{Mat: img.Clone(), Timestamp: time.Now()}:
-					// Successfully sent to the channel
+			select {
+			case reads <- rtspRead{Mat: img, OK: ok}:
+			case <-connCtx.Done():
+				if ok {
+					img.Close()
 				}
+				return
 			}
-
-			img.Close() // Crucial to close the image to avoid memory leaks
 		}
+	}()
+
+	return reads
+}
+
+// sendCameraEvent reports a camera event to the error and alert streams
+func sendCameraEvent(canxCtx context.Context, errorStream chan interface{}, alertStream chan AlertData, camera model.Camera, event string, err model.CustomError) {
+	select {
+	case errorStream <- err:
+	case <-canxCtx.Done():
+		return
 	}
+
+	trySendAlert(alertStream, AlertData{
+		Camera:    camera,
+		Label:     event,
+		Timestamp: time.Now(),
+		Event:     event,
+	})
 }
 
-func randomFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, streamChannels []chan FrameData) {
+func randomFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, _ chan AlertData, streams []*Stream) error {
 	var startTime = time.Now().Unix()
 	var endTime = time.Now().Unix()
 	var frames = 0
@@ -115,6 +266,8 @@ func randomFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Ca
 		}
 	}()
 
+	keyFrames := keyFrameClock{}
+
 	// Capture frames, route captured frames to streamers and monitor cancellations
 	for {
 		select {
@@ -122,32 +275,27 @@ func randomFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Ca
 			lgr.Logger.Info(
 				"randomFramer context cancelled",
 			)
-			return
+			return nil
 		default:
 			frames++
-			// Determine if we should skip the frame
-			if svcs.InferenceSvc.CanSkipFrame(frames) {
-				skippedFrames++
-				continue
-			}
 
 			// Generate a random frame
 			img := gocv.NewMatWithSize(480, 640, gocv.MatTypeCV8UC3) // Create a 480x640 image with 3 channels (BGR)
+			now := time.Now()
 			// Route the frame to multiple streamers
-			for _, streamChan := range streamChannels {
-				// WARNING: We need an extra check to make sure we don't send on c closed channel
-				select {
-				case <-canxCtx.Done():
-					// Context canceled, stop sending
-					lgr.Logger.Info("randomFramer context cancelled while sending!!")
-					img.Close() // Crucial to close the image to avoid memory leaks
-					return
-				case streamChan <- FrameData{Mat: img.Clone(), Timestamp: time.Now()}:
-					// Successfully sent to the channel
-				}
+			frame := FrameData{Frame: newFrame(svcs, img), Timestamp: now, KeyFrame: keyFrames.next(now)}
+			routed, more := routeFrame(canxCtx, streams, frame)
+			frame.Release() // Crucial to release the frame to avoid memory leaks
+			if !more {
+				// Context canceled, stop sending
+				lgr.Logger.Info("randomFramer context cancelled while sending!!")
+				return nil
 			}
 
-			img.Close() // Crucial to close the image to avoid memory leaks
+			// No streamer sampled the frame
+			if routed == 0 {
+				skippedFrames++
+			}
 		}
 	}
 }
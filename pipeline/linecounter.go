@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"log/slog"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+)
+
+// Crossing directions
+const (
+	lineDirectionIn  = "in"
+	lineDirectionOut = "out"
+)
+
+// Tracks that are not seen for this long are forgotten by the line counters
+const linePositionTTL = 30 * time.Second
+
+// linePosition is where a track was last seen off the line
+type linePosition struct {
+	Point image.Point
+	Seen  time.Time
+}
+
+// lineCounter counts the tracks crossing a camera line
+type lineCounter struct {
+	Line model.Line
+	// Counts since the last published stats
+	In  int
+	Out int
+	// Crossing times (within the alert window) per direction. Only kept for the alerts.
+	Crossings map[string][]time.Time
+	// Last alert time per direction
+	Alerted map[string]time.Time
+	// Track ID -> position
+	Positions map[int]linePosition
+}
+
+func newLineCounter(line model.Line) *lineCounter {
+	return &lineCounter{
+		Line:      line,
+		Crossings: map[string][]time.Time{},
+		Alerted:   map[string]time.Time{},
+		Positions: map[int]linePosition{},
+	}
+}
+
+// update moves the tracks of the frame and returns the directions of the tracks that crossed the line
+func (c *lineCounter) update(tracks trackFrame) []string {
+	from := image.Pt(int(c.Line.From.X*float64(tracks.Width)), int(c.Line.From.Y*float64(tracks.Height)))
+	to := image.Pt(int(c.Line.To.X*float64(tracks.Width)), int(c.Line.To.Y*float64(tracks.Height)))
+
+	crossings := []string{}
+	for _, track := range tracks.Tracks {
+		point := footPoint(track.Box)
+
+		// Positions on the line do not tell on which side the track is
+		if orientation(from, to, point) == 0 {
+			continue
+		}
+
+		previous, ok := c.Positions[track.ID]
+		c.Positions[track.ID] = linePosition{Point: point, Seen: tracks.Timestamp}
+		if !ok || !segmentsCross(previous.Point, point, from, to) {
+			continue
+		}
+
+		// To the right of the line when looking from `from` to `to` (the image Y axis points down)
+		direction := lineDirectionOut
+		if orientation(from, to, point) > 0 {
+			direction = lineDirectionIn
+			c.In++
+		} else {
+			c.Out++
+		}
+		crossings = append(crossings, direction)
+	}
+
+	for id, position := range c.Positions {
+		if tracks.Timestamp.Sub(position.Seen) > linePositionTTL {
+			delete(c.Positions, id)
+		}
+	}
+
+	return crossings
+}
+
+// crossed records a crossing in the direction and returns the number of crossings in the direction within the window
+func (c *lineCounter) crossed(direction string, timestamp time.Time, window time.Duration) int {
+	crossings := append(c.Crossings[direction], timestamp)
+	kept := crossings[:0]
+	for _, crossed := range crossings {
+		if timestamp.Sub(crossed) <= window {
+			kept = append(kept, crossed)
+		}
+	}
+	c.Crossings[direction] = kept
+
+	return len(kept)
+}
+
+// stats returns the counts since the last call
+func (c *lineCounter) stats(camera model.Camera, period time.Duration) model.CountStats {
+	stats := model.CountStats{
+		Name:   config.LineCounterName,
+		Camera: camera.Name,
+		Line:   c.Line.Name,
+		In:     c.In,
+		Out:    c.Out,
+		Period: int64(period.Seconds()),
+	}
+
+	c.In = 0
+	c.Out = 0
+	return stats
+}
+
+// LineCounter counts the objects that cross the camera lines in each direction. It builds on
+// the tracks of the camera's `yolo5Detector` (which must run with tracking) and only keeps the
+// latest frame for its alerts. The counts are published as count stats every `countPeriod`
+// seconds (and when the streamer stops). With `countThreshold`, it alerts when more than
+// `countThreshold` objects cross a line in the same direction within `countWindow` seconds
+// (at most once per `coolDownPeriod`).
+func LineCounter(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.LineCounterName, 10)
+
+	go func() {
+		tracks, unsubscribe := subscribeTracks(camera.ID, 100)
+		defer unsubscribe()
+
+		counters := make([]*lineCounter, len(camera.Lines))
+		for i, line := range camera.Lines {
+			counters[i] = newLineCounter(line)
+		}
+
+		lgr.Logger.Info(
+			"line counter initialized...",
+			slog.String("camera", camera.Name),
+			slog.Int("lines", len(counters)),
+		)
+
+		// The latest frame is kept for the alerts
+		latest := latestFrame{}
+		defer latest.release()
+
+		lastPublished := time.Now()
+		publish := func() {
+			period := time.Since(lastPublished)
+			lastPublished = time.Now()
+			for _, counter := range counters {
+				statsStream <- counter.stats(camera, period)
+			}
+		}
+
+		frames := 0
+		updates := 0
+		beginTime := time.Now()
+
+		defer func() {
+			publish()
+			statsStream <- trackStreamerStats(config.LineCounterName, camera, in, frames, updates, beginTime)
+		}()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-canx.Done():
+				lgr.Logger.Info("line counter context cancelled")
+				time.Sleep(waitBeforeCancel)
+				in.drain()
+				return
+
+			case f, ok := <-in.C:
+				if !ok {
+					return
+				}
+				frames++
+				latest.set(f.Frame)
+
+			case update := <-tracks:
+				updates++
+
+				params := in.Params()
+				window := time.Duration(params.CountWindow) * time.Second
+				cooldown := time.Duration(params.CoolDownPeriod) * time.Second
+
+				for _, counter := range counters {
+					for _, direction := range counter.update(update) {
+						if params.CountThreshold == 0 {
+							continue
+						}
+
+						count := counter.crossed(direction, update.Timestamp, window)
+						if count <= params.CountThreshold || time.Since(counter.Alerted[direction]) <= cooldown {
+							continue
+						}
+						counter.Alerted[direction] = time.Now()
+						latest.alert(alertStream, AlertData{
+							Camera:     camera,
+							Timestamp:  time.Now(),
+							Label:      "lineCrossing",
+							Confidence: 100.0,
+							Line:       counter.Line.Name,
+							Direction:  direction,
+							Count:      count,
+						})
+					}
+				}
+
+			case <-ticker.C:
+				params := in.Params()
+				if params.CountPeriod > 0 && time.Since(lastPublished) >= time.Duration(params.CountPeriod)*time.Second {
+					publish()
+				}
+			}
+		}
+	}()
+
+	return in
+}
@@ -0,0 +1,154 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"log/slog"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+)
+
+// Tracks that are not seen for this long are forgotten by the loitering detector
+const loiterTrackTTL = 30 * time.Second
+
+// loiterVisit is a track staying in a zone. A track that leaves and re-enters a zone starts a new visit.
+type loiterVisit struct {
+	TrackID int
+	Zone    string
+	Since   time.Time
+}
+
+// loiterDetector finds the tracks that stay in a zone (or in view) longer than the dwell threshold
+type loiterDetector struct {
+	// Visits that were alerted -> last time their track was seen
+	Alerted map[loiterVisit]time.Time
+}
+
+func newLoiterDetector() *loiterDetector {
+	return &loiterDetector{
+		Alerted: map[loiterVisit]time.Time{},
+	}
+}
+
+// loiterAlert is a track that stayed in the zone for the dwell time
+type loiterAlert struct {
+	Track Track
+	Zone  string
+	Dwell time.Duration
+}
+
+// update returns the visits of the frame tracks that reached the threshold. Each visit is returned once.
+// If the camera has no zones, the visit is the time that the track is in view.
+func (d *loiterDetector) update(tracks trackFrame, zoned bool, threshold time.Duration) []loiterAlert {
+	alerts := []loiterAlert{}
+
+	for _, track := range tracks.Tracks {
+		visits := []loiterVisit{}
+		if zoned {
+			for zone, since := range track.Zones {
+				visits = append(visits, loiterVisit{TrackID: track.ID, Zone: zone, Since: since})
+			}
+		} else {
+			visits = append(visits, loiterVisit{TrackID: track.ID, Since: track.FirstSeen})
+		}
+
+		for _, visit := range visits {
+			if _, ok := d.Alerted[visit]; ok {
+				d.Alerted[visit] = tracks.Timestamp
+				continue
+			}
+
+			dwell := tracks.Timestamp.Sub(visit.Since)
+			if dwell < threshold {
+				continue
+			}
+
+			d.Alerted[visit] = tracks.Timestamp
+			alerts = append(alerts, loiterAlert{Track: track, Zone: visit.Zone, Dwell: dwell})
+		}
+	}
+
+	for visit, seen := range d.Alerted {
+		if tracks.Timestamp.Sub(seen) > loiterTrackTTL {
+			delete(d.Alerted, visit)
+		}
+	}
+
+	return alerts
+}
+
+// LoiteringDetector alerts when an object stays in a camera zone for more than `dwellThreshold` seconds
+// (i.e. a person at the back door for more than a minute). If the camera has no zones, it alerts
+// when an object stays in view for that long. It builds on the tracks of the camera's `yolo5Detector`
+// (which must run with tracking), alerts once per visit with the dwell time and only keeps the
+// latest frame for its alerts.
+func LoiteringDetector(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.LoiteringDetectorName, 10)
+
+	go func() {
+		tracks, unsubscribe := subscribeTracks(camera.ID, 100)
+		defer unsubscribe()
+
+		detector := newLoiterDetector()
+
+		lgr.Logger.Info(
+			"loitering detector initialized...",
+			slog.String("camera", camera.Name),
+			slog.Int("zones", len(camera.Zones)),
+		)
+
+		// The latest frame is kept for the alerts
+		latest := latestFrame{}
+		defer latest.release()
+
+		frames := 0
+		updates := 0
+		beginTime := time.Now()
+
+		defer func() {
+			statsStream <- trackStreamerStats(config.LoiteringDetectorName, camera, in, frames, updates, beginTime)
+		}()
+
+		for {
+			select {
+			case <-canx.Done():
+				lgr.Logger.Info("loitering detector context cancelled")
+				time.Sleep(waitBeforeCancel)
+				in.drain()
+				return
+
+			case f, ok := <-in.C:
+				if !ok {
+					return
+				}
+				frames++
+				latest.set(f.Frame)
+
+			case update := <-tracks:
+				updates++
+
+				params := in.Params()
+				threshold := time.Duration(params.DwellThreshold) * time.Second
+
+				for _, loiter := range detector.update(update, len(camera.Zones) > 0, threshold) {
+					latest.alert(alertStream, AlertData{
+						Camera:     camera,
+						Timestamp:  time.Now(),
+						Label:      loiter.Track.Label,
+						Confidence: loiter.Track.Confidence,
+						Boxes:      []image.Rectangle{loiter.Track.Box},
+						TrackID:    loiter.Track.ID,
+						Trajectory: loiter.Track.Trajectory,
+						Zone:       loiter.Zone,
+						Dwell:      loiter.Dwell,
+					})
+				}
+			}
+		}
+	}()
+
+	return in
+}
@@ -0,0 +1,276 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+	"gocv.io/x/gocv"
+)
+
+// Frames processed by the motion detector are blurred with this kernel to cut the sensor noise
+var motionBlurSize = image.Pt(5, 5)
+
+// backgroundSubtractor is implemented by the gocv MOG2 and KNN subtractors
+type backgroundSubtractor interface {
+	Apply(src gocv.Mat, dst *gocv.Mat) error
+	Close() error
+}
+
+// motionGate is opened by the motion detector of a camera (in gate mode) while it sees motion.
+// The streams of the streamers that are gated by motion only accept frames while it is open.
+type motionGate struct {
+	// Unix nano time until which the gate is open
+	until atomic.Int64
+}
+
+// Camera ID -> *motionGate
+var motionGates sync.Map
+
+func (g *motionGate) open(hold time.Duration) {
+	g.until.Store(time.Now().Add(hold).UnixNano())
+}
+
+func (g *motionGate) isOpen() bool {
+	return time.Now().UnixNano() < g.until.Load()
+}
+
+// motionAllowed tells whether the streamers of the camera that are gated by motion may get frames.
+// Cameras without a motion detector in gate mode are never gated.
+func motionAllowed(cameraID string) bool {
+	gate, ok := motionGates.Load(cameraID)
+	return !ok || gate.(*motionGate).isOpen()
+}
+
+// MotionDetector detects motion with a MOG2 or KNN background subtractor. Moving blobs smaller
+// than `minBlobArea` pixels are ignored and the first `warmupFrames` frames are only used to learn
+// the background. The subtractor, its `sensitivity` and the gate mode (`motionGate` and `motionHoldPeriod`)
+// are set when the streamer starts since changing them on the fly would reset the background model or
+// leave the gated streamers behind a gate that nobody opens any longer.
+// By default the detector alerts with the bounding boxes of the motion (at most once per `coolDownPeriod`).
+// In gate mode (`motionGate`) it does not alert. Instead, the streamers that have `gatedByMotion`
+// only get frames while there is motion and for `motionHoldPeriod` seconds after it so that
+// the YOLO detector (for example) only runs when something moves.
+func MotionDetector(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.MotionDetectorName, 100)
+
+	go func() {
+		params := in.Params()
+		subtractor := newBackgroundSubtractor(params)
+		defer subtractor.Close()
+
+		gated := params.MotionGate
+		hold := time.Duration(params.MotionHoldPeriod) * time.Second
+		gate := &motionGate{}
+		if gated {
+			// The gate is kept open until the detector warmed up so that gated streamers are not starved meanwhile
+			gate.open(hold)
+			motionGates.Store(camera.ID, gate)
+			defer motionGates.CompareAndDelete(camera.ID, gate)
+		}
+
+		lgr.Logger.Info(
+			"motion detector initialized...",
+			slog.String("camera", camera.Name),
+			slog.String("subtractor", params.Subtractor),
+			slog.Bool("gate", gated),
+		)
+
+		blurred := gocv.NewMat()
+		defer blurred.Close()
+		mask := gocv.NewMat()
+		defer mask.Close()
+		kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Pt(3, 3))
+		defer kernel.Close()
+
+		var lastAlertTime time.Time
+
+		proc := func(frame FrameData, frames int) error {
+			defer frame.Release()
+
+			params := in.Params()
+
+			// The frame is shared with the other streamers so it must not be modified
+			mat := frame.Mat()
+			if mat.Empty() {
+				return nil
+			}
+
+			err := gocv.GaussianBlur(mat, &blurred, motionBlurSize, 0, 0, gocv.BorderDefault)
+			if err != nil {
+				return fmt.Errorf("error blurring frame: %w", err)
+			}
+
+			err = subtractor.Apply(blurred, &mask)
+			if err != nil {
+				return fmt.Errorf("error applying background subtractor: %w", err)
+			}
+
+			// The background model needs a few frames before its foreground can be trusted
+			if frames < params.WarmupFrames {
+				if gated {
+					gate.open(hold)
+				}
+				return nil
+			}
+
+			// Drop the shadows (which the subtractors mark in gray) and the speckles
+			gocv.Threshold(mask, &mask, 200, 255, gocv.ThresholdBinary)
+			err = gocv.MorphologyEx(mask, &mask, gocv.MorphOpen, kernel)
+			if err != nil {
+				return fmt.Errorf("error cleaning the foreground mask: %w", err)
+			}
+
+			boxes := motionBoxes(mask, params.MinBlobArea)
+			if len(boxes) == 0 {
+				return nil
+			}
+
+			if gated {
+				gate.open(hold)
+				return nil
+			}
+
+			// With zones, only the motion inside a zone alerts
+			zone := ""
+			if len(camera.Zones) > 0 {
+				boxes, zone = zoneBoxes(frameZones(camera.Zones, mat.Cols(), mat.Rows()), boxes)
+				if len(boxes) == 0 {
+					return nil
+				}
+			}
+
+			if time.Since(lastAlertTime) <= time.Duration(params.CoolDownPeriod)*time.Second {
+				return nil
+			}
+			lastAlertTime = time.Now()
+
+			trySendAlert(alertStream, AlertData{
+				Mat:        frame.Clone(),
+				Camera:     camera,
+				Timestamp:  time.Now(),
+				Label:      "motion",
+				Confidence: 100.0,
+				Boxes:      boxes,
+				Zone:       zone,
+			})
+
+			return nil
+		}
+
+		frames := 0
+		beginTime := time.Now().Unix()
+		endTime := time.Now().Unix()
+		errors := 0
+		var totalInferenceTime time.Duration
+
+		defer func() {
+			endTime = time.Now().Unix()
+			uptime := endTime - beginTime
+			fps := int(float64(frames) / float64(uptime))
+
+			var avgProcTime float64
+			if frames > 0 {
+				avgProcTime = totalInferenceTime.Seconds() / float64(frames)
+			}
+
+			stats := model.StreamerStats{
+				Name:        "motionDetector",
+				Worker:      -1,
+				Camera:      camera.Name,
+				Frames:      frames,
+				Errors:      errors,
+				Uptime:      uptime,
+				FPS:         fps,
+				AvgProcTime: avgProcTime,
+			}
+			in.FillStats(&stats)
+			statsStream <- stats
+		}()
+
+		// The background model is updated frame by frame so the frames are processed in order by a single worker
+		for f := range in.C {
+			select {
+			case <-canx.Done():
+				lgr.Logger.Info("motion detector context cancelled")
+				f.Release()
+				time.Sleep(waitBeforeCancel)
+				in.drain()
+				return
+			default:
+				startInference := time.Now()
+				err := proc(f, frames)
+				if err != nil {
+					errors++
+					errorStream <- model.GenError("agent_motion_detector",
+						err,
+						map[string]interface{}{},
+						"error detecting motion on camera %s",
+						camera.Name)
+				}
+				frames++
+				totalInferenceTime += time.Since(startInference)
+			}
+		}
+	}()
+
+	return in
+}
+
+// newBackgroundSubtractor maps the sensitivity (0 to 1) to the subtractor threshold.
+// A sensitivity of 0.5 gives the OpenCV default threshold and each 0.25 step halves (or doubles) it.
+func newBackgroundSubtractor(params config.StreamerParameters) backgroundSubtractor {
+	scale := math.Pow(2, (0.5-params.Sensitivity)*4)
+
+	if params.Subtractor == config.SubtractorKNN {
+		knn := gocv.NewBackgroundSubtractorKNNWithParams(500, 400*scale, true)
+		return &knn
+	}
+
+	mog2 := gocv.NewBackgroundSubtractorMOG2WithParams(500, 16*scale, true)
+	return &mog2
+}
+
+// motionBoxes returns the bounding boxes of the foreground blobs of at least minArea pixels
+func motionBoxes(mask gocv.Mat, minArea int) []image.Rectangle {
+	contours := gocv.FindContours(mask, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	defer contours.Close()
+
+	boxes := []image.Rectangle{}
+	for i := 0; i < contours.Size(); i++ {
+		contour := contours.At(i)
+		if gocv.ContourArea(contour) < float64(minArea) {
+			continue
+		}
+		boxes = append(boxes, gocv.BoundingRect(contour))
+	}
+
+	return boxes
+}
+
+// zoneBoxes returns the boxes that are inside a zone and the zone of the first one
+func zoneBoxes(zones []frameZone, boxes []image.Rectangle) ([]image.Rectangle, string) {
+	inside := []image.Rectangle{}
+	zone := ""
+	for _, box := range boxes {
+		names := boxZones(zones, box)
+		if len(names) == 0 {
+			continue
+		}
+
+		if zone == "" {
+			zone = names[0]
+		}
+		inside = append(inside, box)
+	}
+
+	return inside, zone
+}
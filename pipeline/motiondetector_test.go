@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"gocv.io/x/gocv"
+)
+
+func TestMotionGateIsKeptAcrossConfigChanges(t *testing.T) {
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+
+	// The detector stays in its warmup so that it opens its gate for every frame
+	cfgSvc := newFakeConfig()
+	cfgSvc.Params[config.MotionDetectorName] = config.StreamerParameters{MotionParameters: config.MotionParameters{MotionGate: true, MotionHoldPeriod: 1, WarmupFrames: 1000}}
+	svcs := ServicesFactory{CfgSvc: cfgSvc}
+	camera := model.Camera{ID: "motion-gate-test", Name: "motion gate"}
+
+	in := MotionDetector(canxCtx, svcs, camera, make(chan interface{}, 100), make(chan interface{}, 100), make(chan AlertData, 100))
+	defer closeStreams([]*Stream{in})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := motionGates.Load(camera.ID); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("motion gate was not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Turning the gate mode off on the fly does not leave the gated streamers behind a closed gate
+	cfgSvc.set(config.MotionDetectorName, config.StreamerParameters{MotionParameters: config.MotionParameters{MotionGate: false, MotionHoldPeriod: 1, WarmupFrames: 1000}})
+	for sent := time.Now(); time.Since(sent) < 1500*time.Millisecond; {
+		in.C <- FrameData{Frame: newFrame(svcs, gocv.NewMat()), Timestamp: time.Now()}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if in.Params().MotionGate {
+		t.Fatalf("configuration change was not picked up")
+	}
+	if !motionAllowed(camera.ID) {
+		t.Fatalf("motion gate was closed by a configuration change")
+	}
+}
@@ -19,12 +19,10 @@ import (
 // This is because GoCV produces uncompressed frames, which might generate large/huge MP4 files.
 // GoCV is optimized for frame processing and inference.
 // RTSP Low-level library is used for WebRTC broadcasting.
-func MP4Recorder(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) chan FrameData {
-	in := make(chan FrameData, 100)
+func MP4Recorder(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.MP4RecorderName, 100)
 
 	go func() {
-		defer close(in)
-
 		var buffer []FrameData
 		var recordingTime = time.Now()
 
@@ -33,21 +31,22 @@ func MP4Recorder(canx context.Context, svcs ServicesFactory, camera model.Camera
 			slog.String("camera", camera.Name),
 		)
 
-		flush := func(clonedBuffer []FrameData) {
+		// flush takes over the frames of the buffer and releases them once the clip is saved
+		flush := func(clipBuffer []FrameData) {
 			defer func() {
-				for _, f := range clonedBuffer {
-					f.Mat.Close()
+				for _, f := range clipBuffer {
+					f.Release()
 				}
 				if r := recover(); r != nil {
 					lgr.Logger.Error("flush panic recovered:", r)
 				}
 			}()
 
-			if len(clonedBuffer) == 0 {
+			if len(clipBuffer) == 0 {
 				return
 			}
 
-			fn, err := saveFramesAsMP4(svcs, camera, clonedBuffer)
+			fn, err := saveFramesAsMP4(svcs, camera, clipBuffer)
 			if err != nil {
 				errorStream <- model.GenError("agent_mp4_recorder",
 					err,
@@ -108,25 +107,15 @@ func MP4Recorder(canx context.Context, svcs ServicesFactory, camera model.Camera
 		proc := func(frame FrameData) bool {
 			buffer = append(buffer, frame)
 
-			if time.Since(recordingTime) >= time.Duration(svcs.CfgSvc.GetStreamerParameters(config.MP4RecorderName).ClipDuration)*time.Second {
-				clonedBuffer := make([]FrameData, len(buffer))
-				for i, f := range buffer {
-					clonedBuffer[i] = FrameData{
-						Mat:       f.Mat.Clone(),
-						Timestamp: f.Timestamp,
-					}
-				}
-
-				// Close original frames immediately (not deferred)
-				for _, f := range buffer {
-					f.Mat.Close()
-				}
+			if time.Since(recordingTime) >= time.Duration(in.Params().ClipDuration)*time.Second {
+				// Frames are read-only so they are handed over to the flush rather than copied
+				clipBuffer := buffer
 
 				// Reset buffer slice and capacity
-				buffer = make([]FrameData, 0, len(buffer))
+				buffer = make([]FrameData, 0, len(clipBuffer))
 
 				// Launch flush as a goroutine
-				go flush(clonedBuffer)
+				go flush(clipBuffer)
 				return true
 			}
 			return false
@@ -148,7 +137,7 @@ func MP4Recorder(canx context.Context, svcs ServicesFactory, camera model.Camera
 				avgProcTime = totalInferenceTime.Seconds() / float64(frames)
 			}
 
-			statsStream <- model.StreamerStats{
+			stats := model.StreamerStats{
 				Name:        "mp4Recorder",
 				Worker:      -1,
 				Camera:      camera.Name,
@@ -158,28 +147,24 @@ func MP4Recorder(canx context.Context, svcs ServicesFactory, camera model.Camera
 				FPS:         fps,
 				AvgProcTime: avgProcTime,
 			}
+			in.FillStats(&stats)
+			statsStream <- stats
 		}()
 
 		defer func() {
 			// Final flush on shutdown
 			if len(buffer) > 0 {
-				clonedBuffer := make([]FrameData, len(buffer))
-				for i, f := range buffer {
-					clonedBuffer[i] = FrameData{
-						Mat:       f.Mat.Clone(),
-						Timestamp: f.Timestamp,
-					}
-					f.Mat.Close()
-				}
-				go flush(clonedBuffer)
+				go flush(buffer)
 			}
 		}()
 
-		for f := range in {
+		for f := range in.C {
 			select {
 			case <-canx.Done():
 				lgr.Logger.Info("recorder context cancelled")
+				f.Release()
 				time.Sleep(waitBeforeCancel)
+				in.drain()
 				return
 			default:
 				startInference := time.Now()
@@ -206,23 +191,24 @@ func saveFramesAsMP4(svcs ServicesFactory, camera model.Camera, frames []FrameDa
 		return "", fmt.Errorf("no frames to save")
 	}
 
-	if frames[0].Mat.Empty() {
+	first := frames[0].Mat()
+	if first.Empty() {
 		lgr.Logger.Error(
 			"frames[0].Mat is empty or invalid",
 			slog.Int("frame_index", 0),
-			slog.Int("cols", frames[0].Mat.Cols()),
-			slog.Int("rows", frames[0].Mat.Rows()),
+			slog.Int("cols", first.Cols()),
+			slog.Int("rows", first.Rows()),
 		)
 		return "", fmt.Errorf("invalid Mat in frames[0]")
 	}
 
-	if frames[0].Mat.Cols() <= 0 || frames[0].Mat.Rows() <= 0 {
+	if first.Cols() <= 0 || first.Rows() <= 0 {
 		lgr.Logger.Error(
 			"invalid frame dimensions",
-			slog.Int("cols", frames[0].Mat.Cols()),
-			slog.Int("rows", frames[0].Mat.Rows()),
+			slog.Int("cols", first.Cols()),
+			slog.Int("rows", first.Rows()),
 		)
-		return "", fmt.Errorf("invalid frame dimensions: cols=%d, rows=%d", frames[0].Mat.Cols(), frames[0].Mat.Rows())
+		return "", fmt.Errorf("invalid frame dimensions: cols=%d, rows=%d", first.Cols(), first.Rows())
 	}
 
 	filename := fmt.Sprintf("%s/%s_recording_%d.mp4", svcs.CfgSvc.GetRecordingsFolder(), camera.Name, time.Now().Unix())
@@ -233,7 +219,7 @@ func saveFramesAsMP4(svcs ServicesFactory, camera model.Camera, frames []FrameDa
 		slog.String("filename", filename),
 	)
 
-	writer, err := gocv.VideoWriterFile(filename, "avc1", 30, frames[0].Mat.Cols(), frames[0].Mat.Rows(), true)
+	writer, err := gocv.VideoWriterFile(filename, "avc1", 30, first.Cols(), first.Rows(), true)
 	if err != nil {
 		lgr.Logger.Error(
 			"error creating video writer",
@@ -244,20 +230,21 @@ func saveFramesAsMP4(svcs ServicesFactory, camera model.Camera, frames []FrameDa
 	defer writer.Close()
 
 	for _, f := range frames {
+		mat := f.Mat()
 		// Check if the frame dimensions match the video dimensions
-		if f.Mat.Cols() != frames[0].Mat.Cols() || f.Mat.Rows() != frames[0].Mat.Rows() {
+		if mat.Cols() != first.Cols() || mat.Rows() != first.Rows() {
 			lgr.Logger.Warn(
 				"frame dimensions do not match video dimensions, resizing frame",
-				slog.Int("frame_cols", f.Mat.Cols()),
-				slog.Int("frame_rows", f.Mat.Rows()),
-				slog.Int("video_cols", frames[0].Mat.Cols()),
-				slog.Int("video_rows", frames[0].Mat.Rows()),
+				slog.Int("frame_cols", mat.Cols()),
+				slog.Int("frame_rows", mat.Rows()),
+				slog.Int("video_cols", first.Cols()),
+				slog.Int("video_rows", first.Rows()),
 			)
 
 			// Resize the frame to match the video dimensions
 			resized := gocv.NewMat()
 			defer resized.Close()
-			err := gocv.Resize(f.Mat, &resized, image.Pt(frames[0].Mat.Cols(), frames[0].Mat.Rows()), 0, 0, gocv.InterpolationLinear)
+			err := gocv.Resize(mat, &resized, image.Pt(first.Cols(), first.Rows()), 0, 0, gocv.InterpolationLinear)
 			if err != nil {
 				lgr.Logger.Error(
 					"error creating video writer",
@@ -277,7 +264,7 @@ func saveFramesAsMP4(svcs ServicesFactory, camera model.Camera, frames []FrameDa
 			}
 		} else {
 			// Write the frame as is
-			err := writer.Write(f.Mat)
+			err := writer.Write(mat)
 			if err != nil {
 				lgr.Logger.Error(
 					"error creating video writer",
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"log/slog"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+)
+
+// streamerParameters merges the configured streamer parameters with the camera's overrides.
+// Merging decodes and validates the overrides so streamers get the merged parameters from
+// their stream (see `Stream.Params`) which only merges them again when the configuration changes.
+func streamerParameters(svcs ServicesFactory, camera model.Camera, name string) config.StreamerParameters {
+	params := svcs.CfgSvc.GetStreamerParameters(name)
+
+	overridden, err := config.OverrideStreamerParameters(name, params, camera.StreamerParameters[name])
+	if err != nil {
+		// Overrides are validated when the agent starts so this should not happen
+		lgr.Logger.Error(
+			"error applying camera streamer parameters. Using the configured parameters",
+			slog.String("camera", camera.Name),
+			slog.String("streamer", name),
+			slog.Any("error", err),
+		)
+		return params
+	}
+
+	return overridden
+}
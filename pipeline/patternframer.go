@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+	"gocv.io/x/gocv"
+)
+
+// patternOptions are read from the camera URL query parameters:
+// - fps: frames per second (defaults to 15)
+// - width, height: frame resolution (defaults to 640x480)
+// - shapes: number of bouncing circles (defaults to 3)
+// - events: path of a JSON file of scripted events (see patternEvent)
+// - loop: `true` restarts the events script once its last event ended
+// i.e. `?fps=10&width=1280&height=720&events=./settings/intrusion.json&loop=true`
+type patternOptions struct {
+	FPS    float64
+	Width  int
+	Height int
+	Shapes int
+	Events []patternEvent
+	Loop   bool
+}
+
+// patternEvent draws a filled rectangle that moves from `from` to `to` (top-left corners)
+// between `at` and `at + duration` seconds i.e. a person walking into a zone:
+// `{"at": 10, "duration": 5, "from": [0, 200], "to": [300, 200], "size": [60, 160], "label": "person"}`
+type patternEvent struct {
+	At       float64 `json:"at"`
+	Duration float64 `json:"duration"`
+	From     [2]int  `json:"from"`
+	To       [2]int  `json:"to"`
+	Size     [2]int  `json:"size"`
+	Label    string  `json:"label"`
+}
+
+func parsePatternOptions(source string) (patternOptions, error) {
+	opts := patternOptions{FPS: 15, Width: 640, Height: 480, Shapes: 3}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return opts, fmt.Errorf("invalid pattern source %s: %w", source, err)
+	}
+
+	query := u.Query()
+	ints := map[string]*int{
+		"width":  &opts.Width,
+		"height": &opts.Height,
+		"shapes": &opts.Shapes,
+	}
+
+	for key, field := range ints {
+		val := query.Get(key)
+		if val == "" {
+			continue
+		}
+
+		*field, err = strconv.Atoi(val)
+		if err != nil || *field < 0 || (key != "shapes" && *field == 0) {
+			return opts, fmt.Errorf("invalid pattern %s %q", key, val)
+		}
+	}
+
+	if fps := query.Get("fps"); fps != "" {
+		opts.FPS, err = strconv.ParseFloat(fps, 64)
+		if err != nil || opts.FPS <= 0 {
+			return opts, fmt.Errorf("invalid pattern fps %q: must be a positive number", fps)
+		}
+	}
+
+	if loop := query.Get("loop"); loop != "" {
+		opts.Loop, err = strconv.ParseBool(loop)
+		if err != nil {
+			return opts, fmt.Errorf("invalid pattern loop %q: %w", loop, err)
+		}
+	}
+
+	if events := query.Get("events"); events != "" {
+		data, err := os.ReadFile(events)
+		if err != nil {
+			return opts, fmt.Errorf("error reading pattern events %s: %w", events, err)
+		}
+
+		err = json.Unmarshal(data, &opts.Events)
+		if err != nil {
+			return opts, fmt.Errorf("error parsing pattern events %s: %w", events, err)
+		}
+	}
+
+	return opts, nil
+}
+
+// patternFramer generates frames with moving shapes, a timestamp and scripted events
+// at a fixed rate so that streamers can be tested end to end and pods can be
+// load-tested with many fake cameras
+func patternFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, _ chan AlertData, streams []*Stream) error {
+	var startTime = time.Now().Unix()
+	var endTime = time.Now().Unix()
+	var frames = 0
+	var skippedFrames = 0
+	var errors = 0
+
+	defer func() {
+		endTime = time.Now().Unix()
+		uptime := endTime - startTime
+		fps := int(float64(frames) / float64(uptime))
+		statsStream <- model.FramerStats{
+			Name:          "patternFramer",
+			Camera:        camera.Name,
+			Frames:        frames,
+			SkippedFrames: skippedFrames,
+			Errors:        errors,
+			Uptime:        uptime,
+			FPS:           fps,
+		}
+	}()
+
+	opts, err := parsePatternOptions(camera.RtspURL)
+	if err != nil {
+		return fmt.Errorf("error parsing pattern source of camera %s: %w", camera.Name, err)
+	}
+
+	// The script restarts once its last event ended
+	period := 0.0
+	for _, event := range opts.Events {
+		period = max(period, event.At+event.Duration)
+	}
+
+	start := time.Now()
+	for i := 0; ; i++ {
+		if !waitUntil(canxCtx, start.Add(time.Duration(float64(i)/opts.FPS*float64(time.Second)))) {
+			lgr.Logger.Info(
+				"patternFramer context cancelled",
+			)
+			return nil
+		}
+
+		frames++
+		elapsed := float64(i) / opts.FPS
+		scriptTime := elapsed
+		if opts.Loop && period > 0 {
+			scriptTime = elapsed - period*float64(int(elapsed/period))
+		}
+
+		img := drawPattern(opts, camera, i, elapsed, scriptTime)
+		frame := FrameData{Frame: newFrame(svcs, img), Timestamp: time.Now(), KeyFrame: isKeyFrame(i, opts.FPS)}
+		routed, more := routeFrame(canxCtx, streams, frame)
+		frame.Release() // Crucial to release the frame to avoid memory leaks
+
+		// No streamer sampled the frame
+		if routed == 0 {
+			skippedFrames++
+		}
+
+		if !more {
+			lgr.Logger.Info("patternFramer context cancelled while sending!!")
+			return nil
+		}
+	}
+}
+
+var (
+	patternShapeColor = color.RGBA{R: 0, G: 200, B: 255, A: 0}
+	patternEventColor = color.RGBA{R: 255, G: 255, B: 255, A: 0}
+	patternTextColor  = color.RGBA{R: 0, G: 255, B: 0, A: 0}
+)
+
+func drawPattern(opts patternOptions, camera model.Camera, frame int, elapsed, scriptTime float64) gocv.Mat {
+	img := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(40, 40, 40, 0), opts.Height, opts.Width, gocv.MatTypeCV8UC3)
+
+	// Circles bounce around at different speeds so that consecutive frames always differ
+	radius := max(min(opts.Width, opts.Height)/20, 4)
+	for s := 0; s < opts.Shapes; s++ {
+		speed := 60.0 + 40.0*float64(s) // pixels per second
+		x := bounce(float64(radius)+float64(s)*97+speed*elapsed, radius, opts.Width-radius)
+		y := bounce(float64(radius)+float64(s)*61+speed*0.7*elapsed, radius, opts.Height-radius)
+		gocv.Circle(&img, image.Pt(x, y), radius, patternShapeColor, -1)
+	}
+
+	for _, event := range opts.Events {
+		if scriptTime < event.At || scriptTime > event.At+event.Duration {
+			continue
+		}
+
+		progress := 1.0
+		if event.Duration > 0 {
+			progress = (scriptTime - event.At) / event.Duration
+		}
+
+		x := event.From[0] + int(float64(event.To[0]-event.From[0])*progress)
+		y := event.From[1] + int(float64(event.To[1]-event.From[1])*progress)
+		gocv.Rectangle(&img, image.Rect(x, y, x+event.Size[0], y+event.Size[1]), patternEventColor, -1)
+		if event.Label != "" {
+			gocv.PutText(&img, event.Label, image.Pt(x, max(y-5, 10)), gocv.FontHersheySimplex, 0.5, patternEventColor, 1)
+		}
+	}
+
+	text := fmt.Sprintf("%s %s #%d", camera.Name, time.Now().Format("15:04:05.000"), frame)
+	gocv.PutText(&img, text, image.Pt(10, 20), gocv.FontHersheySimplex, 0.5, patternTextColor, 1)
+
+	return img
+}
+
+// bounce folds a position that keeps growing into [low, high] back and forth
+func bounce(pos float64, low, high int) int {
+	span := float64(high - low)
+	if span <= 0 {
+		return low
+	}
+
+	p := pos - float64(low)
+	p -= 2 * span * float64(int(p/(2*span)))
+	if p > span {
+		p = 2*span - p
+	}
+
+	return low + int(p)
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+// Registries map names to streamers, alerters and framers so that pipelines
+// can be assembled from configuration or camera records.
+// Library implementations are registered below. Integrators register their own
+// before starting the mode processor i.e. `pipeline.RegisterStreamer("myDetector", MyDetector)`.
+var (
+	streamerRegistry = newRegistry[Streamer]("streamer")
+	alerterRegistry  = newRegistry[Alerter]("alerter")
+	framerRegistry   = newRegistry[Framer]("framer")
+)
+
+func init() {
+	mustRegister(streamerRegistry, config.MP4RecorderName, MP4Recorder)
+	mustRegister(streamerRegistry, config.SimpleDetectorName, SimpleDetector)
+	mustRegister(streamerRegistry, config.Yolo5DetectorName, Yolo5Detector)
+	mustRegister(streamerRegistry, config.WebrtcBroadcasterName, WebrtcBroadcaster)
+	mustRegister(streamerRegistry, config.MotionDetectorName, MotionDetector)
+	mustRegister(streamerRegistry, config.LineCounterName, LineCounter)
+	mustRegister(streamerRegistry, config.LoiteringDetectorName, LoiteringDetector)
+
+	mustRegister(alerterRegistry, config.SimpleAlerterName, SimpleAlerter)
+
+	mustRegister(framerRegistry, config.RandomFramerName, randomFramer)
+	mustRegister(framerRegistry, config.RTSPFramerName, rtspFramer)
+	mustRegister(framerRegistry, config.ReplayFramerName, replayFramer)
+	mustRegister(framerRegistry, config.PatternFramerName, patternFramer)
+}
+
+// RegisterStreamer makes a streamer available by name. Names must be unique.
+func RegisterStreamer(name string, streamer Streamer) error {
+	return streamerRegistry.register(name, streamer)
+}
+
+// LookupStreamer returns the streamer registered under the name
+func LookupStreamer(name string) (Streamer, error) {
+	return streamerRegistry.lookup(name)
+}
+
+// StreamerNames returns the sorted names of all registered streamers
+func StreamerNames() []string {
+	return streamerRegistry.names()
+}
+
+// RegisterAlerter makes an alerter available by name. Names must be unique.
+func RegisterAlerter(name string, alerter Alerter) error {
+	return alerterRegistry.register(name, alerter)
+}
+
+// LookupAlerter returns the alerter registered under the name
+func LookupAlerter(name string) (Alerter, error) {
+	return alerterRegistry.lookup(name)
+}
+
+// AlerterNames returns the sorted names of all registered alerters
+func AlerterNames() []string {
+	return alerterRegistry.names()
+}
+
+// RegisterFramer makes a framer available by name. Cameras select it via their `framerType`.
+func RegisterFramer(name string, framer Framer) error {
+	return framerRegistry.register(name, framer)
+}
+
+// LookupFramer returns the framer registered under the name
+func LookupFramer(name string) (Framer, error) {
+	return framerRegistry.lookup(name)
+}
+
+// FramerNames returns the sorted names of all registered framers
+func FramerNames() []string {
+	return framerRegistry.names()
+}
+
+type registry[T any] struct {
+	kind  string
+	mutex sync.RWMutex
+	items map[string]T
+}
+
+func newRegistry[T any](kind string) *registry[T] {
+	return &registry[T]{
+		kind:  kind,
+		items: map[string]T{},
+	}
+}
+
+func (r *registry[T]) register(name string, item T) error {
+	if name == "" {
+		return fmt.Errorf("%s name must not be empty", r.kind)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.items[name]; ok {
+		return fmt.Errorf("%s %s is already registered", r.kind, name)
+	}
+
+	r.items[name] = item
+	return nil
+}
+
+func (r *registry[T]) lookup(name string) (T, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	item, ok := r.items[name]
+	if !ok {
+		return item, fmt.Errorf("unknown %s: %s", r.kind, name)
+	}
+
+	return item, nil
+}
+
+func (r *registry[T]) names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.items))
+	for name := range r.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func mustRegister[T any](r *registry[T], name string, item T) {
+	err := r.register(name, item)
+	if err != nil {
+		panic(err)
+	}
+}
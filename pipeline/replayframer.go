@@ -0,0 +1,273 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+	"gocv.io/x/gocv"
+)
+
+// Pacing of JPEG directories and of videos that do not report their frame rate
+const defaultReplayFPS = 10
+
+// replayOptions are read from the camera URL which is the path of an MP4 file or
+// of a directory of JPEGs followed by optional query parameters:
+// - pace: `original` (default) paces frames by their timestamps, `fast` runs as fast as possible
+// - loop: `true` replays the source forever
+// - fps: pacing of JPEG directories (defaults to 10)
+// i.e. `./recordings/camera_1_1745179677.mp4?pace=fast&loop=true`
+type replayOptions struct {
+	Path string
+	Fast bool
+	Loop bool
+	FPS  float64
+}
+
+func parseReplayOptions(source string) (replayOptions, error) {
+	opts := replayOptions{FPS: defaultReplayFPS}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return opts, fmt.Errorf("invalid replay source %s: %w", source, err)
+	}
+
+	opts.Path = u.Path
+	if opts.Path == "" {
+		return opts, fmt.Errorf("replay source %s has no path", source)
+	}
+
+	query := u.Query()
+	switch pace := query.Get("pace"); pace {
+	case "", "original":
+	case "fast":
+		opts.Fast = true
+	default:
+		return opts, fmt.Errorf("invalid replay pace %q: use original or fast", pace)
+	}
+
+	if loop := query.Get("loop"); loop != "" {
+		opts.Loop, err = strconv.ParseBool(loop)
+		if err != nil {
+			return opts, fmt.Errorf("invalid replay loop %q: %w", loop, err)
+		}
+	}
+
+	if fps := query.Get("fps"); fps != "" {
+		opts.FPS, err = strconv.ParseFloat(fps, 64)
+		if err != nil || opts.FPS <= 0 {
+			return opts, fmt.Errorf("invalid replay fps %q: must be a positive number", fps)
+		}
+	}
+
+	return opts, nil
+}
+
+// replayFramer replays an MP4 file or a directory of JPEGs as if it were a live camera
+// so that streamers and alerters can be run against recorded incidents
+func replayFramer(canxCtx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, _ chan AlertData, streams []*Stream) error {
+	var startTime = time.Now().Unix()
+	var endTime = time.Now().Unix()
+	var frames = 0
+	var skippedFrames = 0
+	var errors = 0
+
+	defer func() {
+		endTime = time.Now().Unix()
+		uptime := endTime - startTime
+		fps := int(float64(frames) / float64(uptime))
+		statsStream <- model.FramerStats{
+			Name:          "replayFramer",
+			Camera:        camera.Name,
+			Frames:        frames,
+			SkippedFrames: skippedFrames,
+			Errors:        errors,
+			Uptime:        uptime,
+			FPS:           fps,
+		}
+	}()
+
+	opts, err := parseReplayOptions(camera.RtspURL)
+	if err != nil {
+		errors++
+		return fmt.Errorf("error parsing replay source of camera %s: %w", camera.Name, err)
+	}
+
+	// emit takes ownership of the image
+	emit := func(img gocv.Mat, timestamp time.Time, keyFrame bool) bool {
+		frames++
+		frame := FrameData{Frame: newFrame(svcs, img), Timestamp: timestamp, KeyFrame: keyFrame}
+		routed, more := routeFrame(canxCtx, streams, frame)
+		frame.Release() // Crucial to release the frame to avoid memory leaks
+
+		// No streamer sampled the frame
+		if routed == 0 {
+			skippedFrames++
+		}
+
+		return more
+	}
+
+	// Frame timestamps are the replay start time plus the frame position in the source
+	// so that streamers see the original timing even when the replay runs fast.
+	// Loops carry on from the end of the previous loop.
+	origin := time.Now()
+	for {
+		var end time.Time
+		var more bool
+		info, err := os.Stat(opts.Path)
+		if err == nil && info.IsDir() {
+			end, more, err = replayImages(canxCtx, opts, origin, emit)
+		} else {
+			end, more, err = replayVideo(canxCtx, opts, origin, emit)
+		}
+
+		if err != nil {
+			errors++
+			return fmt.Errorf("error replaying %s: %w", opts.Path, err)
+		}
+
+		if !more {
+			lgr.Logger.Info(
+				"replayFramer context cancelled",
+			)
+			return nil
+		}
+
+		if !opts.Loop {
+			lgr.Logger.Info(
+				"replayFramer reached the end of the source",
+				slog.String("camera", camera.Name),
+				slog.String("source", opts.Path),
+				slog.Int("frames", frames),
+			)
+			return ErrSourceEnded
+		}
+
+		origin = end
+		if !opts.Fast && origin.Before(time.Now()) {
+			// Do not rush the next loop to catch up with a slow one
+			origin = time.Now()
+		}
+	}
+}
+
+// replayVideo plays the video once with the frame timestamps starting at the origin.
+// It returns the end time of the video and false if it was cancelled.
+func replayVideo(canxCtx context.Context, opts replayOptions, origin time.Time, emit func(img gocv.Mat, timestamp time.Time, keyFrame bool) bool) (time.Time, bool, error) {
+	video, err := gocv.VideoCaptureFile(opts.Path)
+	if err != nil {
+		return origin, false, fmt.Errorf("error opening video %s: %w", opts.Path, err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = opts.FPS
+	}
+	interval := time.Duration(float64(time.Second) / fps)
+
+	end := origin
+	for i := 0; ; i++ {
+		// Frames are shared with the streamers so each one needs its own image
+		img := gocv.NewMat()
+		if ok := video.Read(&img); !ok || img.Empty() {
+			// End of the video
+			img.Close() // Crucial to close the image to avoid memory leaks
+			if i == 0 {
+				return origin, false, fmt.Errorf("video %s has no frames", opts.Path)
+			}
+			return end, true, nil
+		}
+
+		// The frame position or, if the position is not reported, the frame rate
+		offset := time.Duration(video.Get(gocv.VideoCapturePosMsec) * float64(time.Millisecond))
+		if offset <= 0 {
+			offset = time.Duration(i) * interval
+		}
+		timestamp := origin.Add(offset)
+		end = timestamp.Add(interval)
+
+		if !opts.Fast && !waitUntil(canxCtx, timestamp) {
+			img.Close()
+			return end, false, nil
+		}
+
+		if !emit(img, timestamp, isKeyFrame(i, fps)) {
+			return end, false, nil
+		}
+	}
+}
+
+// replayImages plays the JPEGs of a directory once in file name order with the frame
+// timestamps starting at the origin. It returns the end time of the directory and false if it was cancelled.
+func replayImages(canxCtx context.Context, opts replayOptions, origin time.Time, emit func(img gocv.Mat, timestamp time.Time, keyFrame bool) bool) (time.Time, bool, error) {
+	entries, err := os.ReadDir(opts.Path)
+	if err != nil {
+		return origin, false, fmt.Errorf("error reading directory %s: %w", opts.Path, err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if !entry.IsDir() && (ext == ".jpg" || ext == ".jpeg") {
+			files = append(files, filepath.Join(opts.Path, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	if len(files) == 0 {
+		return origin, false, fmt.Errorf("directory %s has no JPEG files", opts.Path)
+	}
+
+	timestampOf := func(i int) time.Time {
+		return origin.Add(time.Duration(float64(i) / opts.FPS * float64(time.Second)))
+	}
+
+	for i, file := range files {
+		img := gocv.IMRead(file, gocv.IMReadColor)
+		if img.Empty() {
+			img.Close()
+			return timestampOf(i), false, fmt.Errorf("error reading image %s", file)
+		}
+
+		timestamp := timestampOf(i)
+		if !opts.Fast && !waitUntil(canxCtx, timestamp) {
+			img.Close()
+			return timestamp, false, nil
+		}
+
+		if !emit(img, timestamp, isKeyFrame(i, opts.FPS)) {
+			return timestamp, false, nil
+		}
+	}
+
+	return timestampOf(len(files)), true, nil
+}
+
+// waitUntil returns false if it was cancelled before the deadline
+func waitUntil(canxCtx context.Context, deadline time.Time) bool {
+	delay := time.Until(deadline)
+	if delay <= 0 {
+		return canxCtx.Err() == nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-canxCtx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
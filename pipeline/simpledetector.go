@@ -6,15 +6,14 @@ import (
 	"time"
 
 	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
 	"github.com/khaledhikmat/vs-go/service/lgr"
 )
 
-func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, alertStream chan AlertData) chan FrameData {
-	in := make(chan FrameData, 100)
+func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.SimpleDetectorName, 100)
 
 	go func() {
-		defer close(in)
-
 		lgr.Logger.Info(
 			"simple detector initialized...",
 			slog.String("camera", camera.Name),
@@ -26,7 +25,7 @@ func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Cam
 		defer flush()
 
 		proc := func(frame FrameData, frames, worker int) {
-			defer frame.Mat.Close()
+			defer frame.Release()
 
 			lgr.Logger.Debug(
 				"simple detector processing frame",
@@ -43,7 +42,7 @@ func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Cam
 				(worker == 2 && frames == 3000) {
 				// Send alert to the alert stream
 				alertStream <- AlertData{
-					Mat:        frame.Mat.Clone(),
+					Mat:        frame.Clone(),
 					FrameURL:   "",
 					ClipURL:    "",
 					Camera:     camera,
@@ -57,7 +56,7 @@ func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Cam
 		// Launch worker processes that compete on emptying/procesing frames
 		for i := 0; i < svcs.CfgSvc.GetStreamerMaxWorkers(); i++ {
 			worker := i // Capture the loop variable
-			go func(worker int, in chan FrameData) {
+			go func(worker int, in *Stream) {
 				frames := 0
 				beginTime := time.Now().Unix()
 				endTime := time.Now().Unix()
@@ -79,7 +78,7 @@ func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Cam
 						AvgProcTime = totalInferenceTime.Seconds() / float64(frames)
 					}
 
-					statsStream <- model.StreamerStats{
+					stats := model.StreamerStats{
 						Name:        "simpleDetector",
 						Worker:      worker,
 						Camera:      camera.Name,
@@ -89,15 +88,22 @@ func SimpleDetector(canx context.Context, svcs ServicesFactory, camera model.Cam
 						FPS:         fps,
 						AvgProcTime: AvgProcTime,
 					}
+					if worker == 0 {
+						// The workers share the input stream so only the first one reports its counters
+						in.FillStats(&stats)
+					}
+					statsStream <- stats
 				}()
 
-				for f := range in {
+				for f := range in.C {
 					select {
 					case <-canx.Done():
 						lgr.Logger.Info(
 							"simple detector worker context cancelled",
 							slog.Int("worker", worker),
 						)
+						f.Release()
+						in.drain()
 						return
 					default:
 						// Process frame
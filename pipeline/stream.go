@@ -0,0 +1,268 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+// Stream is the input of a streamer. Framers offer every captured frame to every stream
+// and the stream's sampling policy (taken from the streamer parameters and the camera's
+// overrides) decides whether the streamer gets it. When the streamer falls behind, its
+// backpressure policy decides whether the framer waits or frames are dropped.
+// Streamers create their stream with `NewStream`, read frames from `C`, get their parameters
+// with `Params` and report the stream counters with `FillStats`. The agent closes `C` once the framer returned (see `closeStreams`)
+// so streamers must not close it. Streamers that stop on cancellation release the frames left in `C` with `drain`.
+// `Accept` must only be called by the framer.
+type Stream struct {
+	// C carries the sampled frames to the streamer
+	C chan FrameData
+
+	name   string
+	svcs   ServicesFactory
+	camera model.Camera
+
+	// The merged parameters are kept until the configuration changes
+	paramsMutex sync.Mutex
+	params      config.StreamerParameters
+	changes     <-chan struct{}
+
+	offered  int
+	lastSent time.Time
+
+	// Counters are updated by the framer and read by the streamer
+	dropped      atomic.Int64
+	depthSum     atomic.Int64
+	depthSamples atomic.Int64
+	maxDepth     atomic.Int64
+}
+
+// NewStream creates the input of the named streamer with a channel buffer of the given size.
+// The stream watches the configuration until the context is cancelled.
+func NewStream(canx context.Context, svcs ServicesFactory, camera model.Camera, name string, size int) *Stream {
+	return &Stream{
+		C:       make(chan FrameData, size),
+		name:    name,
+		svcs:    svcs,
+		camera:  camera,
+		params:  streamerParameters(svcs, camera, name),
+		changes: svcs.CfgSvc.Watch(canx),
+	}
+}
+
+// Name returns the name of the stream's streamer
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// Params returns the streamer parameters with the camera's overrides applied. They are merged
+// once and merged again when the configuration changes so it is cheap enough to call for every
+// frame. Streamers should call it whenever they need a parameter rather than keep the parameters
+// so that configuration changes are picked up on the fly. It is safe to call concurrently.
+func (s *Stream) Params() config.StreamerParameters {
+	s.paramsMutex.Lock()
+	defer s.paramsMutex.Unlock()
+
+	select {
+	case _, ok := <-s.changes:
+		if ok {
+			s.params = streamerParameters(s.svcs, s.camera, s.name)
+		} else {
+			// The stream was cancelled so the configuration is no longer watched
+			s.changes = nil
+		}
+	default:
+	}
+
+	return s.params
+}
+
+// Accept tells whether the frame should be routed to the streamer
+func (s *Stream) Accept(frame FrameData) bool {
+	params := s.Params()
+
+	// Streamers gated by motion only get frames while the camera's motion detector sees motion
+	if params.GatedByMotion && !motionAllowed(s.camera.ID) {
+		return false
+	}
+
+	s.offered++
+
+	switch params.Sampling {
+	case config.SamplingEveryNth:
+		return (s.offered-1)%max(params.SampleEvery, 1) == 0
+
+	case config.SamplingMaxFPS:
+		interval := time.Duration(float64(time.Second) / params.MaxFPS)
+		if !s.lastSent.IsZero() && frame.Timestamp.Sub(s.lastSent) < interval {
+			return false
+		}
+		s.lastSent = frame.Timestamp
+		return true
+
+	case config.SamplingKeyFrames:
+		return frame.KeyFrame
+
+	default:
+		return true
+	}
+}
+
+// FillStats sets the stream counters of the streamer stats
+func (s *Stream) FillStats(stats *model.StreamerStats) {
+	stats.DroppedFrames = int(s.dropped.Load())
+	stats.MaxQueueDepth = int(s.maxDepth.Load())
+	if samples := s.depthSamples.Load(); samples > 0 {
+		stats.AvgQueueDepth = float64(s.depthSum.Load()) / float64(samples)
+	}
+}
+
+// send queues the frame according to the backpressure policy and takes ownership of its reference.
+// It returns false if it was cancelled.
+func (s *Stream) send(canxCtx context.Context, frame FrameData) bool {
+	// Measure the queue depth as the framer sees it
+	depth := int64(len(s.C))
+	s.depthSum.Add(depth)
+	s.depthSamples.Add(1)
+	if depth > s.maxDepth.Load() {
+		s.maxDepth.Store(depth)
+	}
+
+	// Do not queue frames that nobody will process
+	if canxCtx.Err() != nil {
+		frame.Release()
+		return false
+	}
+
+	params := s.Params()
+	switch params.Backpressure {
+	case config.BackpressureDropNewest:
+		select {
+		case s.C <- frame:
+		default:
+			frame.Release()
+			s.dropped.Add(1)
+		}
+		return true
+
+	case config.BackpressureDropOldest, config.BackpressureLatest:
+		if params.Backpressure == config.BackpressureLatest {
+			for len(s.C) > 0 {
+				if !s.dropOldest() {
+					frame.Release()
+					return false
+				}
+			}
+		}
+
+		for {
+			select {
+			case s.C <- frame:
+				return true
+			default:
+				// Make room. The workers may have made room in the meantime.
+				if !s.dropOldest() {
+					frame.Release()
+					return false
+				}
+			}
+		}
+
+	default:
+		select {
+		case <-canxCtx.Done():
+			// Context canceled, stop sending
+			frame.Release()
+			return false
+		case s.C <- frame:
+			// Successfully sent to the channel
+			return true
+		}
+	}
+}
+
+// dropOldest drops the oldest queued frame if any. It returns false if the stream is closed
+// (which cannot happen while the framer sends).
+func (s *Stream) dropOldest() bool {
+	select {
+	case old, ok := <-s.C:
+		if !ok {
+			return false
+		}
+		old.Release()
+		s.dropped.Add(1)
+	default:
+	}
+
+	return true
+}
+
+// routeFrame shares the frame with every stream that accepts it.
+// It returns the number of streams that accepted the frame (even if their backpressure
+// policy dropped it) and false if it was cancelled.
+// The caller still holds (and releases) its own reference.
+func routeFrame(canxCtx context.Context, streams []*Stream, frame FrameData) (int, bool) {
+	routed := 0
+	for _, stream := range streams {
+		if !stream.Accept(frame) {
+			continue
+		}
+
+		if !stream.send(canxCtx, FrameData{Frame: frame.Retain(), Timestamp: frame.Timestamp, KeyFrame: frame.KeyFrame}) {
+			return routed, false
+		}
+		routed++
+	}
+
+	return routed, true
+}
+
+// closeStreams closes the streams once their framer returned. The channels are closed by the
+// sending side so a streamer that stops on its own never makes the framer send on a closed channel.
+// Streamers ranging over their input process the queued frames and stop.
+func closeStreams(streams []*Stream) {
+	for _, stream := range streams {
+		close(stream.C)
+	}
+}
+
+// drain releases the frames left in the stream until the agent closes it (see `closeStreams`).
+// Streamers that stop on cancellation call it so that the frames queued by the framer are not leaked.
+func (s *Stream) drain() {
+	for f := range s.C {
+		f.Release()
+	}
+}
+
+// GoCV hands out decoded frames and does not tell which ones were key frames in the
+// compressed stream. Framers approximate them with one key frame per second of video
+// which matches the usual GOP of IP cameras.
+
+// isKeyFrame flags one frame per second of video for framers that know their frame rate
+func isKeyFrame(frame int, fps float64) bool {
+	return frame%max(int(math.Round(fps)), 1) == 0
+}
+
+// keyFrameClock flags one frame per second of wall time for framers that do not know their frame rate
+type keyFrameClock struct {
+	last time.Time
+}
+
+func (k *keyFrameClock) next(timestamp time.Time) bool {
+	if !k.last.IsZero() && timestamp.Sub(k.last) < time.Second {
+		return false
+	}
+
+	k.last = timestamp
+	return true
+}
+
+// reset makes the next frame a key frame (i.e. after a reconnect)
+func (k *keyFrameClock) reset() {
+	k.last = time.Time{}
+}
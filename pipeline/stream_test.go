@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+	"gocv.io/x/gocv"
+)
+
+// fakeConfig serves the streamer parameters that the tests set and signals their changes to
+// the watchers. The methods that the tests do not need are left to the embedded (nil) interface.
+type fakeConfig struct {
+	config.IService
+
+	mu       sync.Mutex
+	Params   map[string]config.StreamerParameters
+	Reads    int
+	Watchers []chan struct{}
+}
+
+func newFakeConfig() *fakeConfig {
+	return &fakeConfig{
+		Params: map[string]config.StreamerParameters{},
+	}
+}
+
+func (svc *fakeConfig) GetStreamerParameters(name string) config.StreamerParameters {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.Reads++
+	return svc.Params[name]
+}
+
+func (svc *fakeConfig) GetFrameDebug() bool {
+	return true
+}
+
+func (svc *fakeConfig) Watch(canxCtx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	svc.mu.Lock()
+	svc.Watchers = append(svc.Watchers, changes)
+	svc.mu.Unlock()
+
+	go func() {
+		<-canxCtx.Done()
+
+		svc.mu.Lock()
+		defer svc.mu.Unlock()
+		for i, watcher := range svc.Watchers {
+			if watcher == changes {
+				svc.Watchers = append(svc.Watchers[:i], svc.Watchers[i+1:]...)
+				break
+			}
+		}
+		close(changes)
+	}()
+
+	return changes
+}
+
+// set changes the parameters of the streamer and signals the watchers
+func (svc *fakeConfig) set(name string, params config.StreamerParameters) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	svc.Params[name] = params
+	for _, watcher := range svc.Watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (svc *fakeConfig) reads() int {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.Reads
+}
+
+func TestStreamParamsAreMergedOnceUntilTheConfigChanges(t *testing.T) {
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+
+	cfgSvc := newFakeConfig()
+	cfgSvc.Params[config.LineCounterName] = config.StreamerParameters{ConfidenceThreshold: 0.4, CoolDownPeriod: 10}
+	camera := model.Camera{
+		ID:   "params-test",
+		Name: "params",
+		StreamerParameters: map[string]map[string]interface{}{
+			config.LineCounterName: {"coolDownPeriod": 30},
+		},
+	}
+
+	stream := NewStream(canxCtx, ServicesFactory{CfgSvc: cfgSvc}, camera, config.LineCounterName, 1)
+	for i := 0; i < 100; i++ {
+		params := stream.Params()
+		if params.ConfidenceThreshold != 0.4 || params.CoolDownPeriod != 30 {
+			t.Fatalf("unexpected parameters %+v", params)
+		}
+	}
+	if reads := cfgSvc.reads(); reads != 1 {
+		t.Fatalf("parameters were merged %d times without a configuration change", reads)
+	}
+
+	// A change merges the parameters again and the camera overrides still apply
+	cfgSvc.set(config.LineCounterName, config.StreamerParameters{ConfidenceThreshold: 0.6, CoolDownPeriod: 10})
+	params := stream.Params()
+	if params.ConfidenceThreshold != 0.6 || params.CoolDownPeriod != 30 {
+		t.Fatalf("configuration change was not picked up: %+v", params)
+	}
+	stream.Params()
+	if reads := cfgSvc.reads(); reads != 2 {
+		t.Fatalf("parameters were merged %d times after one configuration change", reads)
+	}
+
+	// Once cancelled, the stream keeps its parameters
+	canxFn()
+	for i := 0; i < 10; i++ {
+		if stream.Params().ConfidenceThreshold != 0.6 {
+			t.Fatalf("cancelled stream lost its parameters")
+		}
+	}
+	if reads := cfgSvc.reads(); reads != 2 {
+		t.Fatalf("cancelled stream merged its parameters %d times", reads)
+	}
+}
+
+func TestCancelledStreamersReleaseQueuedFrames(t *testing.T) {
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+
+	svcs := ServicesFactory{CfgSvc: newFakeConfig()}
+	camera := model.Camera{ID: "drain-test", Name: "drain"}
+	errorStream := make(chan interface{}, 100)
+	statsStream := make(chan interface{}, 100)
+	alertStream := make(chan AlertData, 100)
+
+	before := LiveFrames()
+	streams := []*Stream{}
+	for _, streamer := range []Streamer{LineCounter, LoiteringDetector, MP4Recorder} {
+		streams = append(streams, streamer(canxCtx, svcs, camera, errorStream, statsStream, alertStream))
+	}
+
+	// The framer queued frames right before the agent was cancelled
+	canxFn()
+	for _, stream := range streams {
+		for i := 0; i < cap(stream.C); i++ {
+			stream.C <- FrameData{Frame: newFrame(svcs, gocv.NewMat()), Timestamp: time.Now()}
+		}
+	}
+	closeStreams(streams)
+
+	deadline := time.Now().Add(2*waitBeforeCancel + 5*time.Second)
+	for LiveFrames() != before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d frames were not released", LiveFrames()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
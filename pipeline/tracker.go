@@ -0,0 +1,249 @@
+package pipeline
+
+import (
+	"image"
+	"sort"
+	"time"
+)
+
+// Number of trajectory points kept per track
+const trackMaxTrajectory = 50
+
+// Track is an object followed across frames. IDs are stable for the lifetime of the tracker
+// (i.e. the camera agent) and are never reused.
+type Track struct {
+	ID         int
+	Label      string
+	Confidence float32
+	Box        image.Rectangle
+	// Trajectory holds the box centers from the oldest to the latest (capped)
+	Trajectory []image.Point
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	// Hits is the number of frames in which the track was detected
+	Hits int
+	// Confirmed is set once the track has enough hits to be trusted
+	Confirmed bool
+	// Zones maps the camera zones that the track is in to when it entered them
+	Zones map[string]time.Time
+	// Entered holds the zones that a confirmed track entered with the latest update.
+	// When a track is confirmed, it enters all the zones that it is in.
+	Entered []string
+
+	// Velocity in pixels per second of the box center
+	vx, vy float64
+}
+
+// trackDetection is a detection to be tracked
+type trackDetection struct {
+	Label      string
+	Confidence float32
+	Box        image.Rectangle
+	// Zones holds the camera zones that the detection is in
+	Zones []string
+}
+
+// trackUpdate holds the snapshots of the tracks that changed with an update
+type trackUpdate struct {
+	// Tracks that were confirmed by the update
+	Confirmed []Track
+	// Confirmed tracks that entered zones with the update (see `Track.Entered`)
+	Entered []Track
+	// Confirmed tracks that were detected in the frame
+	Tracks []Track
+}
+
+// tracker follows detections across frames by matching each detection to the track with the highest
+// IoU (intersection over union) after moving the track along its velocity (a simplified SORT).
+// Tracks are confirmed after minHits detections and dropped when they are not seen for maxAge.
+// It must be updated with the frames in order so streamers with several workers put their
+// results back in frame order first (see `Yolo5Detector`). It is not safe for concurrent use.
+type tracker struct {
+	Tracks []*Track
+	LastID int
+}
+
+func newTracker() *tracker {
+	return &tracker{}
+}
+
+// update matches the detections of the frame to the tracks. It returns the tracks that
+// changed with this update.
+func (t *tracker) update(detections []trackDetection, timestamp time.Time, iouThreshold float64, minHits int, maxAge time.Duration) trackUpdate {
+	result := trackUpdate{}
+
+	// Score every same label track/detection pair and match the best pairs first
+	type pair struct {
+		track     int
+		detection int
+		iou       float64
+	}
+	pairs := []pair{}
+	for i, track := range t.Tracks {
+		predicted := track.predict(timestamp)
+		for j, detection := range detections {
+			if detection.Label != track.Label {
+				continue
+			}
+			if iou := boxIoU(predicted, detection.Box); iou >= iouThreshold {
+				pairs = append(pairs, pair{track: i, detection: j, iou: iou})
+			}
+		}
+	}
+	sort.Slice(pairs, func(a, b int) bool { return pairs[a].iou > pairs[b].iou })
+
+	matchedTracks := map[int]bool{}
+	matchedDetections := map[int]bool{}
+	for _, p := range pairs {
+		if matchedTracks[p.track] || matchedDetections[p.detection] {
+			continue
+		}
+		matchedTracks[p.track] = true
+		matchedDetections[p.detection] = true
+
+		track := t.Tracks[p.track]
+		entered := track.observe(detections[p.detection], timestamp)
+		result.add(track, entered, minHits)
+	}
+
+	// Drop the tracks that were not seen for too long
+	alive := t.Tracks[:0]
+	for _, track := range t.Tracks {
+		if timestamp.Sub(track.LastSeen) <= maxAge {
+			alive = append(alive, track)
+		}
+	}
+	t.Tracks = alive
+
+	// Unmatched detections start new tracks
+	for j, detection := range detections {
+		if matchedDetections[j] {
+			continue
+		}
+
+		t.LastID++
+		track := &Track{
+			ID:        t.LastID,
+			Label:     detection.Label,
+			FirstSeen: timestamp,
+		}
+		entered := track.observe(detection, timestamp)
+		t.Tracks = append(t.Tracks, track)
+		result.add(track, entered, minHits)
+	}
+
+	for _, track := range t.Tracks {
+		if track.Confirmed && track.LastSeen.Equal(timestamp) {
+			result.Tracks = append(result.Tracks, track.snapshot())
+		}
+	}
+
+	return result
+}
+
+// add confirms the track once it has enough hits and records the zones that it entered
+func (u *trackUpdate) add(track *Track, entered []string, minHits int) {
+	track.Entered = nil
+
+	if !track.Confirmed {
+		if track.Hits < minHits {
+			return
+		}
+
+		track.Confirmed = true
+		u.Confirmed = append(u.Confirmed, track.snapshot())
+
+		// A track that is confirmed inside zones enters them now
+		entered = entered[:0]
+		for zone := range track.Zones {
+			entered = append(entered, zone)
+		}
+		sort.Strings(entered)
+	}
+
+	if len(entered) > 0 {
+		track.Entered = entered
+		u.Entered = append(u.Entered, track.snapshot())
+	}
+}
+
+// predict moves the track box along its velocity to the timestamp
+func (track *Track) predict(timestamp time.Time) image.Rectangle {
+	dt := timestamp.Sub(track.LastSeen).Seconds()
+	return track.Box.Add(image.Pt(int(track.vx*dt), int(track.vy*dt)))
+}
+
+// observe moves the track to the detection and returns the zones that the track entered
+func (track *Track) observe(detection trackDetection, timestamp time.Time) []string {
+	center := boxCenter(detection.Box)
+	if track.Hits > 0 {
+		if dt := timestamp.Sub(track.LastSeen).Seconds(); dt > 0 {
+			previous := boxCenter(track.Box)
+			track.vx = float64(center.X-previous.X) / dt
+			track.vy = float64(center.Y-previous.Y) / dt
+		}
+	}
+
+	track.Box = detection.Box
+	track.Confidence = detection.Confidence
+	track.LastSeen = timestamp
+	track.Hits++
+
+	track.Trajectory = append(track.Trajectory, center)
+	if len(track.Trajectory) > trackMaxTrajectory {
+		track.Trajectory = track.Trajectory[len(track.Trajectory)-trackMaxTrajectory:]
+	}
+
+	entered := []string{}
+	inside := map[string]bool{}
+	for _, zone := range detection.Zones {
+		inside[zone] = true
+		if _, ok := track.Zones[zone]; !ok {
+			if track.Zones == nil {
+				track.Zones = map[string]time.Time{}
+			}
+			track.Zones[zone] = timestamp
+			entered = append(entered, zone)
+		}
+	}
+
+	for zone := range track.Zones {
+		if !inside[zone] {
+			delete(track.Zones, zone)
+		}
+	}
+
+	return entered
+}
+
+// snapshot copies the track so that it can be used outside of the tracker lock
+func (track *Track) snapshot() Track {
+	snapshot := *track
+	snapshot.Trajectory = append([]image.Point{}, track.Trajectory...)
+	snapshot.Entered = append([]string{}, track.Entered...)
+	snapshot.Zones = make(map[string]time.Time, len(track.Zones))
+	for zone, since := range track.Zones {
+		snapshot.Zones[zone] = since
+	}
+	return snapshot
+}
+
+func boxCenter(box image.Rectangle) image.Point {
+	return image.Pt((box.Min.X+box.Max.X)/2, (box.Min.Y+box.Max.Y)/2)
+}
+
+// boxIoU returns the intersection over union of two boxes
+func boxIoU(a, b image.Rectangle) float64 {
+	intersection := a.Intersect(b)
+	if intersection.Empty() {
+		return 0
+	}
+
+	inter := float64(intersection.Dx() * intersection.Dy())
+	union := float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - inter
+	if union <= 0 {
+		return 0
+	}
+
+	return inter / union
+}
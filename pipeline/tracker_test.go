@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestTrackerFollowsDetectionsInOrder(t *testing.T) {
+	tracks := newTracker()
+	start := time.Now()
+
+	confirmed := []Track{}
+	for i := 0; i < 5; i++ {
+		box := image.Rect(10+i*5, 10, 60+i*5, 110)
+		update := tracks.update([]trackDetection{{Label: "person", Confidence: 0.9, Box: box}},
+			start.Add(time.Duration(i)*100*time.Millisecond), 0.3, 3, 2*time.Second)
+		confirmed = append(confirmed, update.Confirmed...)
+	}
+
+	// A person walking past is a single track which is confirmed once
+	if len(tracks.Tracks) != 1 || tracks.Tracks[0].ID != 1 || tracks.Tracks[0].Hits != 5 {
+		t.Fatalf("expected a single track with 5 hits, got %+v", tracks.Tracks)
+	}
+	if len(confirmed) != 1 || confirmed[0].ID != 1 {
+		t.Fatalf("expected the track to be confirmed once, got %+v", confirmed)
+	}
+
+	// The track is dropped once it is not seen for max age
+	tracks.update(nil, start.Add(5*time.Second), 0.3, 3, 2*time.Second)
+	if len(tracks.Tracks) != 0 {
+		t.Fatalf("expected the track to be dropped, got %+v", tracks.Tracks)
+	}
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+)
+
+// trackFrame holds the confirmed tracks of a frame as the YOLO detector of a camera sees them
+type trackFrame struct {
+	Timestamp time.Time
+	// Frame size that the track boxes refer to
+	Width  int
+	Height int
+	Tracks []Track
+}
+
+// trackHub hands the tracks of a camera's YOLO detector to the streamers that analyze them
+// (i.e. the line counter) so that they build on the detections instead of running YOLO again.
+// Subscribers that fall behind miss track frames rather than slowing the detector down.
+// A hub only exists while the camera has subscribers.
+type trackHub struct {
+	Mutex       sync.Mutex
+	Subscribers map[chan trackFrame]bool
+	// Deleted is set once the last subscriber left. Subscribers must then use a new hub.
+	Deleted bool
+}
+
+// Camera ID -> *trackHub
+var trackHubs sync.Map
+
+// subscribeTracks returns a channel of the camera track frames with a buffer of the given size
+// and a function that stops the subscription
+func subscribeTracks(cameraID string, size int) (<-chan trackFrame, func()) {
+	tracks := make(chan trackFrame, size)
+
+	for {
+		value, _ := trackHubs.LoadOrStore(cameraID, &trackHub{Subscribers: map[chan trackFrame]bool{}})
+		hub := value.(*trackHub)
+
+		hub.Mutex.Lock()
+		if hub.Deleted {
+			// The last subscriber of the hub left in the meantime
+			hub.Mutex.Unlock()
+			continue
+		}
+		hub.Subscribers[tracks] = true
+		hub.Mutex.Unlock()
+
+		return tracks, func() {
+			hub.Mutex.Lock()
+			defer hub.Mutex.Unlock()
+
+			delete(hub.Subscribers, tracks)
+			if len(hub.Subscribers) == 0 && !hub.Deleted {
+				hub.Deleted = true
+				trackHubs.CompareAndDelete(cameraID, hub)
+			}
+		}
+	}
+}
+
+// publishTracks sends the track frame to the subscribers of the camera tracks
+func publishTracks(cameraID string, tracks trackFrame) {
+	value, ok := trackHubs.Load(cameraID)
+	if !ok {
+		// Nobody analyzes the camera tracks
+		return
+	}
+	hub := value.(*trackHub)
+
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+
+	for subscriber := range hub.Subscribers {
+		select {
+		case subscriber <- tracks:
+		default:
+		}
+	}
+}
+
+// trackStreamerStats returns the stats of a streamer that analyzes the camera tracks.
+// Its FPS is the rate of the track frames that it analyzed.
+func trackStreamerStats(name string, camera model.Camera, in *Stream, frames, updates int, beginTime time.Time) model.StreamerStats {
+	uptime := int64(time.Since(beginTime).Seconds())
+	stats := model.StreamerStats{
+		Name:   name,
+		Worker: -1,
+		Camera: camera.Name,
+		Frames: frames,
+		Uptime: uptime,
+		FPS:    int(float64(updates) / float64(max(uptime, 1))),
+	}
+	in.FillStats(&stats)
+	return stats
+}
@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackHubIsDeletedWithItsLastSubscriber(t *testing.T) {
+	const cameraID = "trackhub-test"
+
+	// Publishing without subscribers does not create a hub
+	publishTracks(cameraID, trackFrame{})
+	if _, ok := trackHubs.Load(cameraID); ok {
+		t.Fatalf("hub created without subscribers")
+	}
+
+	first, unsubscribeFirst := subscribeTracks(cameraID, 1)
+	second, unsubscribeSecond := subscribeTracks(cameraID, 1)
+
+	now := time.Now()
+	publishTracks(cameraID, trackFrame{Timestamp: now})
+	for _, tracks := range []<-chan trackFrame{first, second} {
+		select {
+		case frame := <-tracks:
+			if !frame.Timestamp.Equal(now) {
+				t.Fatalf("unexpected track frame %+v", frame)
+			}
+		default:
+			t.Fatalf("subscriber did not get the track frame")
+		}
+	}
+
+	unsubscribeFirst()
+	if _, ok := trackHubs.Load(cameraID); !ok {
+		t.Fatalf("hub deleted while it has a subscriber")
+	}
+
+	unsubscribeSecond()
+	if _, ok := trackHubs.Load(cameraID); ok {
+		t.Fatalf("hub kept after its last subscriber left")
+	}
+
+	// A new subscriber gets a new hub
+	third, unsubscribeThird := subscribeTracks(cameraID, 1)
+	defer unsubscribeThird()
+	publishTracks(cameraID, trackFrame{Timestamp: now})
+	select {
+	case <-third:
+	default:
+		t.Fatalf("new subscriber did not get the track frame")
+	}
+}
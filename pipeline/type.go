@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"context"
+	"image"
 	"time"
 
 	"github.com/khaledhikmat/vs-go/model"
@@ -30,8 +31,13 @@ type ServicesFactory struct {
 }
 
 type FrameData struct {
-	Mat       gocv.Mat
+	// The frame is shared by the streamers (see `Frame`).
+	// Streamers call `Release` once they are done with it.
+	*Frame
+	// Timestamp is the capture time
 	Timestamp time.Time
+	// KeyFrame is set by the framer on (approximated) key frames
+	KeyFrame bool
 }
 
 type AlertData struct {
@@ -42,10 +48,33 @@ type AlertData struct {
 	Label      string
 	Confidence float32
 	Timestamp  time.Time
+	// Event is set for camera events (i.e. offline) which carry no frame
+	Event string
+	// Boxes are the regions of the frame that triggered the alert (i.e. motion)
+	Boxes []image.Rectangle
+	// TrackID and Trajectory (the track box centers) are set for alerts on tracked objects
+	TrackID    int
+	Trajectory []image.Point
+	// Zone is the camera zone that the object entered (if the camera has zones)
+	Zone string
+	// Line, Direction and Count are set when more objects than the threshold crossed a camera line
+	Line      string
+	Direction string
+	Count     int
+	// Dwell is how long the object stayed in the zone (or in view) for loitering alerts
+	Dwell time.Duration
 }
 
 // Signature of streamer function
-type Streamer func(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) chan FrameData
+// Streamers return their input stream (see `NewStream`) whose sampling policy decides which frames they get
+type Streamer func(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream
 
 // Signature of alerter function
 type Alerter func(canx context.Context, svcs ServicesFactory, errorStream chan interface{}, statsStream chan interface{}) chan AlertData
+
+// Signature of framer function
+// Framers run until cancelled and offer the captured frames to the streams (see `routeFrame`)
+// Camera events (i.e. offline) are reported to the alert stream
+// Framers return nil when cancelled, `ErrSourceEnded` when a finite source (i.e. a replay) ended
+// and an error when they cannot read their source. The agent stops when its framer returns.
+type Framer func(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData, streams []*Stream) error
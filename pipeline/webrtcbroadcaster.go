@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
 	"github.com/khaledhikmat/vs-go/service/lgr"
 )
 
@@ -16,12 +17,10 @@ import (
 // RTSP Low-level library is used for WebRTC broadcasting.
 // The frames need to be compressed before being sent over the network.
 // The frames need to be converted to a format that is compatible with WebRTC, which can be a bottleneck in the streaming process.
-func WebrtcBroadcaster(canx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, _ chan AlertData) chan FrameData {
-	in := make(chan FrameData, 100)
+func WebrtcBroadcaster(canx context.Context, svcs ServicesFactory, camera model.Camera, _ chan interface{}, statsStream chan interface{}, _ chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.WebrtcBroadcasterName, 100)
 
 	go func() {
-		defer close(in)
-
 		lgr.Logger.Info(
 			"webrtc broadcaster initialized...",
 			slog.String("camera", camera.Name),
@@ -33,7 +32,7 @@ func WebrtcBroadcaster(canx context.Context, svcs ServicesFactory, camera model.
 		defer flush()
 
 		proc := func(frame FrameData) {
-			defer frame.Mat.Close()
+			defer frame.Release()
 
 			// Add logic
 		}
@@ -41,7 +40,7 @@ func WebrtcBroadcaster(canx context.Context, svcs ServicesFactory, camera model.
 		// Launch worker processes that compete on emptying/procesing frames
 		for i := 0; i < svcs.CfgSvc.GetStreamerMaxWorkers(); i++ {
 			worker := i // Capture the loop variable
-			go func(worker int, in chan FrameData) {
+			go func(worker int, in *Stream) {
 				frames := 0
 				beginTime := time.Now().Unix()
 				endTime := time.Now().Unix()
@@ -60,7 +59,7 @@ func WebrtcBroadcaster(canx context.Context, svcs ServicesFactory, camera model.
 						AvgProcTime = totalInferenceTime.Seconds() / float64(frames)
 					}
 
-					statsStream <- model.StreamerStats{
+					stats := model.StreamerStats{
 						Name:        "webrtcBroadcaster",
 						Worker:      worker,
 						Camera:      camera.Name,
@@ -70,15 +69,22 @@ func WebrtcBroadcaster(canx context.Context, svcs ServicesFactory, camera model.
 						FPS:         fps,
 						AvgProcTime: AvgProcTime,
 					}
+					if worker == 0 {
+						// The workers share the input stream so only the first one reports its counters
+						in.FillStats(&stats)
+					}
+					statsStream <- stats
 				}()
 
-				for f := range in {
+				for f := range in.C {
 					select {
 					case <-canx.Done():
 						lgr.Logger.Info(
 							"webrtcBroadcaster worker context cancelled",
 							slog.Int("worker", worker),
 						)
+						f.Release()
+						in.drain()
 						return
 					default:
 						// Process frame
@@ -36,9 +36,24 @@ var y5RowLogger = &lumberjack.Logger{
 	Compress:   true, // compress old logs
 }
 
-var y5AllowedClasses = map[string]bool{
-	"person": true,
-	// Add more as needed
+// Detections of the same label that overlap more than this are considered duplicates
+const y5NMSThreshold = 0.45
+
+// y5Result is a frame handed to a detector worker together with its detections.
+// Seq numbers the frames in the order they arrive so that the results are tracked in that order.
+type y5Result struct {
+	Seq        int64
+	Frame      FrameData
+	Params     config.StreamerParameters
+	Detections []y5Detection
+	// OK is false if the frame could not be processed
+	OK bool
+}
+
+// y5Classes holds the allowlist and the confidence thresholds of the labels by class ID
+type y5Classes struct {
+	Allowed    []bool
+	Thresholds []float32
 }
 
 type y5Detection struct {
@@ -47,21 +62,21 @@ type y5Detection struct {
 	ClassConfidence  float32         `json:"classConfidence"`
 	Confidence       float32         `json:"confidence"`
 	Rect             image.Rectangle `json:"rect"`
+	// Zone is the camera zone that the detection is in (if the camera has zones)
+	Zone string `json:"zone,omitempty"`
 }
 
-func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) chan FrameData {
-	in := make(chan FrameData, 100)
+func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Camera, errorStream chan interface{}, statsStream chan interface{}, alertStream chan AlertData) *Stream {
+	in := NewStream(canx, svcs, camera, config.Yolo5DetectorName, 100)
 
 	go func() {
-		defer close(in)
-
 		lgr.Logger.Info("yolo5 detector starting...",
 			slog.String("camera", camera.Name),
-			slog.String("model", svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).ModelPath),
+			slog.String("model", in.Params().ModelPath),
 			slog.String("openCV", gocv.Version()),
 		)
 
-		modelPath := svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).ModelPath
+		modelPath := in.Params().ModelPath
 		if _, err := os.Stat(modelPath); os.IsNotExist(err) {
 			errorStream <- model.GenError("agent_yolo5_detector",
 				fmt.Errorf("no yolo5 model exists"),
@@ -70,26 +85,30 @@ func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Came
 			return
 		}
 
-		labels := loadLabels(svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).CocoNamesPath)
+		labels := loadLabels(in.Params().CocoNamesPath)
 
 		var lastAlertTime = make(map[string]time.Time)
-		var alertMutex = sync.Mutex{}
-		var cooldown = time.Duration(svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).CoolDownPeriod) * time.Second
 
-		proc := func(frame FrameData, net *gocv.Net) {
-			defer frame.Mat.Close()
+		// With tracking, alerts fire once per new track instead of once per label and cooldown period
+		tracks := newTracker()
+
+		// detect runs the model on the frame. It returns false if the frame could not be processed.
+		detect := func(frame FrameData, net *gocv.Net, params config.StreamerParameters) (detections []y5Detection, ok bool) {
 			defer func() {
 				if r := recover(); r != nil {
 					fmt.Printf("Recovered from panic: %v\n", r)
+					ok = false
 				}
 			}()
 
-			if frame.Mat.Empty() {
+			// The frame is shared with the other streamers so it must not be modified
+			mat := frame.Mat()
+			if mat.Empty() {
 				fmt.Println("Skipping empty frame due to decode error")
-				return
+				return nil, false
 			}
 
-			blob := gocv.BlobFromImage(frame.Mat, 1.0/255.0, image.Pt(640, 640), gocv.NewScalar(0, 0, 0, 0), true, false)
+			blob := gocv.BlobFromImage(mat, 1.0/255.0, image.Pt(640, 640), gocv.NewScalar(0, 0, 0, 0), true, false)
 			defer blob.Close()
 
 			net.SetInput(blob, "")
@@ -100,17 +119,19 @@ func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Came
 			dims := output.Size()
 			if len(dims) != 3 {
 				fmt.Printf("Unexpected DNN output dims: %v\n", dims)
-				return
+				return nil, false
 			}
 
 			reshaped := output.Reshape(1, dims[1])
 			if reshaped.Empty() || reshaped.Rows() == 0 || reshaped.Cols() < 5 {
 				fmt.Println("Reshape failed or invalid dimensions")
 				reshaped.Close()
-				return
+				return nil, false
 			}
 			defer reshaped.Close()
 
+			classes := newY5Classes(labels, params)
+
 			var allDetections []y5Detection
 			for i := 0; i < reshaped.Rows(); i++ {
 				row := reshaped.RowRange(i, i+1)
@@ -121,17 +142,54 @@ func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Came
 					continue
 				}
 
-				if data[4] < svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).ObjectConfidenceThreshold {
+				if data[4] < params.ObjectConfidenceThreshold {
 					continue
 				}
 
-				dets := extractDetections(i, frame.Mat, labels, data,
-					svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).ConfidenceThreshold,
-					svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).ObjectConfidenceThreshold,
-					svcs.CfgSvc.GetStreamerParameters(config.Yolo5DetectorName).Logging)
+				dets := extractDetections(i, mat, labels, data,
+					classes,
+					params.ObjectConfidenceThreshold,
+					params.Logging)
 				allDetections = append(allDetections, dets...)
 			}
 
+			return allDetections, true
+		}
+
+		// alert tracks the detections of a frame and alerts. Frames are alerted in order by a single goroutine.
+		alert := func(result y5Result) {
+			frame := result.Frame
+			params := result.Params
+
+			// Zones are scaled to the frame because the stream resolution may change
+			mat := frame.Mat()
+			zones := frameZones(camera.Zones, mat.Cols(), mat.Rows())
+
+			if params.Tracking {
+				// Tracks must be updated on frames without detections too so that they age
+				trackDetections(camera, tracks, frame, nmsDetections(result.Detections), zones, params, func(track Track, zone string) {
+					trySendAlert(alertStream, AlertData{
+						Mat:        frame.Clone(),
+						Camera:     camera,
+						Timestamp:  time.Now(),
+						Label:      track.Label,
+						Confidence: track.Confidence,
+						Boxes:      []image.Rectangle{track.Box},
+						TrackID:    track.ID,
+						Trajectory: track.Trajectory,
+						Zone:       zone,
+					})
+				})
+				return
+			}
+
+			allDetections := result.Detections
+
+			// With zones, only the detections inside a zone may alert
+			if len(zones) > 0 {
+				allDetections = zoneDetections(zones, allDetections)
+			}
+
 			if len(allDetections) == 0 {
 				return
 			}
@@ -146,35 +204,39 @@ func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Came
 				}
 			}
 
-			shouldAlert := false
-			alertMutex.Lock()
-			lastTime, exists := lastAlertTime[bestDetection.Label]
-			if !exists || time.Since(lastTime) > cooldown {
-				shouldAlert = true
-				lastAlertTime[bestDetection.Label] = time.Now()
-			}
-			alertMutex.Unlock()
-
-			if !shouldAlert {
+			// Each class and zone has its own cooldown
+			cooldown := time.Duration(classCoolDownPeriod(params, bestDetection.Label)) * time.Second
+			key := bestDetection.Label + "/" + bestDetection.Zone
+			lastTime, exists := lastAlertTime[key]
+			if exists && time.Since(lastTime) <= cooldown {
 				return
 			}
+			lastAlertTime[key] = time.Now()
 
-			select {
-			case alertStream <- AlertData{
-				Mat:        frame.Mat.Clone(),
+			trySendAlert(alertStream, AlertData{
+				Mat:        frame.Clone(),
 				Camera:     camera,
 				Timestamp:  time.Now(),
 				Label:      bestDetection.Label,
 				Confidence: bestDetection.ObjectConfidence * bestDetection.ClassConfidence,
-			}:
-			default:
-				lgr.Logger.Warn("alertStream full, dropping alert")
-			}
+				Boxes:      []image.Rectangle{bestDetection.Rect},
+				Zone:       bestDetection.Zone,
+			})
 		}
 
-		for i := 0; i < svcs.CfgSvc.GetStreamerMaxWorkers(); i++ {
+		// The workers run the model concurrently and finish out of order so their results are
+		// put back in frame order before they are tracked and alerted
+		maxWorkers := svcs.CfgSvc.GetStreamerMaxWorkers()
+		jobs := make(chan y5Result)
+		results := make(chan y5Result, maxWorkers)
+
+		var workers sync.WaitGroup
+		for i := 0; i < maxWorkers; i++ {
 			worker := i
-			go func(worker int, in chan FrameData) {
+			workers.Add(1)
+			go func(worker int, in *Stream) {
+				defer workers.Done()
+
 				// WARNING: net is not thread-safe!!!
 				// So it must be created in each worker
 				net := gocv.ReadNet(modelPath, "")
@@ -214,7 +276,7 @@ func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Came
 					if frames > 0 {
 						AvgProcTime = totalInferenceTime.Seconds() / float64(frames)
 					}
-					statsStream <- model.StreamerStats{
+					stats := model.StreamerStats{
 						Name:        "yolo5Detector",
 						Worker:      worker,
 						Camera:      camera.Name,
@@ -224,34 +286,227 @@ func Yolo5Detector(canx context.Context, svcs ServicesFactory, camera model.Came
 						FPS:         fps,
 						AvgProcTime: AvgProcTime,
 					}
+					if worker == 0 {
+						// The workers share the input stream so only the first one reports its counters
+						in.FillStats(&stats)
+					}
+					statsStream <- stats
 				}()
 
-				for f := range in {
-					select {
-					case <-canx.Done():
-						lgr.Logger.Info(
-							"yolo5Detector detector worker context cancelled",
-							slog.Int("worker", worker),
-						)
-						return
-					default:
-						startInference := time.Now()
-						proc(f, &net)
-						frames++
-						totalInferenceTime += time.Since(startInference)
-					}
+				// Every job gets a result (even if the frame could not be processed)
+				// so that the frames after it are not held back
+				for job := range jobs {
+					job.Params = in.Params()
+
+					startInference := time.Now()
+					job.Detections, job.OK = detect(job.Frame, &net, job.Params)
+					frames++
+					totalInferenceTime += time.Since(startInference)
+
+					results <- job
 				}
+
+				lgr.Logger.Info(
+					"yolo5Detector detector worker stopped",
+					slog.Int("worker", worker),
+				)
 			}(worker, in)
 		}
 
-		<-canx.Done()
-		time.Sleep(waitBeforeCancel)
-		lgr.Logger.Info("yolo5Detector detector context cancelled")
+		// Close the results once the workers stopped
+		go func() {
+			workers.Wait()
+			close(results)
+		}()
+
+		// Track and alert the results in frame order
+		alerted := make(chan struct{})
+		go func() {
+			defer close(alerted)
+
+			orderResults(results, func(result y5Result) {
+				if result.OK {
+					alert(result)
+				}
+				result.Frame.Release()
+			})
+		}()
+
+		// Number the frames in the order they arrive and hand them to the workers
+		seq := int64(0)
+		for f := range in.C {
+			if canx.Err() != nil {
+				// Nobody processes the frames any longer
+				f.Release()
+				continue
+			}
+
+			select {
+			case jobs <- y5Result{Seq: seq, Frame: f}:
+				seq++
+			case <-canx.Done():
+				f.Release()
+			}
+		}
+		close(jobs)
+
+		<-alerted
+		lgr.Logger.Info("yolo5Detector detector stopped", slog.String("camera", camera.Name))
 	}()
 
 	return in
 }
 
+// orderResults hands the results to the handler in sequence order (starting at 0) until the results are closed.
+// A result is held back until the results of all the frames before it arrived.
+func orderResults(results <-chan y5Result, handle func(result y5Result)) {
+	pending := map[int64]y5Result{}
+	next := int64(0)
+	for result := range results {
+		pending[result.Seq] = result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			handle(result)
+		}
+	}
+}
+
+// nmsDetections drops the detections that overlap a more confident one of the same label
+func nmsDetections(detections []y5Detection) []y5Detection {
+	byLabel := map[string][]y5Detection{}
+	for _, det := range detections {
+		byLabel[det.Label] = append(byLabel[det.Label], det)
+	}
+
+	kept := []y5Detection{}
+	for _, dets := range byLabel {
+		boxes := make([]image.Rectangle, len(dets))
+		scores := make([]float32, len(dets))
+		for i, det := range dets {
+			boxes[i] = det.Rect
+			scores[i] = det.Confidence
+		}
+
+		for _, i := range gocv.NMSBoxes(boxes, scores, 0, y5NMSThreshold) {
+			kept = append(kept, dets[i])
+		}
+	}
+
+	return kept
+}
+
+// zoneDetections returns the detections that are inside a zone with the (first) zone they are in
+func zoneDetections(zones []frameZone, detections []y5Detection) []y5Detection {
+	inside := []y5Detection{}
+	for _, det := range detections {
+		if names := boxZones(zones, det.Rect); len(names) > 0 {
+			det.Zone = names[0]
+			inside = append(inside, det)
+		}
+	}
+
+	return inside
+}
+
+// trackDetections updates the tracks with the frame detections, publishes them to the streamers
+// that analyze the camera tracks and calls alert for every new confirmed track.
+// If the camera has zones, alert is instead called for every zone that a confirmed track enters.
+func trackDetections(camera model.Camera, tracks *tracker, frame FrameData, detections []y5Detection, zones []frameZone, params config.StreamerParameters, alert func(track Track, zone string)) {
+	tracked := make([]trackDetection, len(detections))
+	for i, det := range detections {
+		tracked[i] = trackDetection{
+			Label:      det.Label,
+			Confidence: det.ObjectConfidence * det.ClassConfidence,
+			Box:        det.Rect,
+			Zones:      boxZones(zones, det.Rect),
+		}
+	}
+
+	update := tracks.update(tracked, frame.Timestamp,
+		params.TrackIoUThreshold,
+		params.TrackMinHits,
+		time.Duration(params.TrackMaxAge)*time.Second)
+
+	mat := frame.Mat()
+	publishTracks(camera.ID, trackFrame{
+		Timestamp: frame.Timestamp,
+		Width:     mat.Cols(),
+		Height:    mat.Rows(),
+		Tracks:    update.Tracks,
+	})
+
+	if len(zones) == 0 {
+		for _, track := range update.Confirmed {
+			alert(track, "")
+		}
+		return
+	}
+
+	for _, track := range update.Entered {
+		for _, zone := range track.Entered {
+			alert(track, zone)
+		}
+	}
+}
+
+// classAllowed tells whether the class is in the allowlist. An empty allowlist only allows the default class.
+func classAllowed(params config.StreamerParameters, label string) bool {
+	classes := params.Classes
+	if len(classes) == 0 {
+		classes = []string{config.DefaultClass}
+	}
+
+	for _, class := range classes {
+		if class == config.ClassAll || strings.EqualFold(class, config.ClassAllAlias) || strings.EqualFold(class, label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// classConfidenceThreshold returns the confidence threshold of the class
+func classConfidenceThreshold(params config.StreamerParameters, label string) float32 {
+	for class, threshold := range params.ClassConfidenceThresholds {
+		if strings.EqualFold(class, label) {
+			return threshold
+		}
+	}
+
+	return params.ConfidenceThreshold
+}
+
+// classCoolDownPeriod returns the cool down period of the class
+func classCoolDownPeriod(params config.StreamerParameters, label string) int {
+	for class, period := range params.ClassCoolDownPeriods {
+		if strings.EqualFold(class, label) {
+			return period
+		}
+	}
+
+	return params.CoolDownPeriod
+}
+
+// newY5Classes resolves the class parameters once per frame rather than for every row of the model output
+func newY5Classes(labels []string, params config.StreamerParameters) y5Classes {
+	classes := y5Classes{
+		Allowed:    make([]bool, len(labels)),
+		Thresholds: make([]float32, len(labels)),
+	}
+
+	for i, label := range labels {
+		classes.Allowed[i] = classAllowed(params, label)
+		classes.Thresholds[i] = classConfidenceThreshold(params, label)
+	}
+
+	return classes
+}
+
 func loadLabels(path string) []string {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -260,7 +515,7 @@ func loadLabels(path string) []string {
 	return strings.Split(strings.TrimSpace(string(data)), "\n")
 }
 
-func extractDetections(idx int, frame gocv.Mat, labels []string, data []float32, confidenceThresh float32, objectConfidenceThresh float32, logging bool) []y5Detection {
+func extractDetections(idx int, frame gocv.Mat, labels []string, data []float32, classes y5Classes, objectConfidenceThresh float32, logging bool) []y5Detection {
 	detections := []y5Detection{}
 
 	if len(data) < 5 {
@@ -279,8 +534,7 @@ func extractDetections(idx int, frame gocv.Mat, labels []string, data []float32,
 	classID := -1
 	classConfidence := float32(0.0)
 	for j, score := range classScores {
-		label := strings.ToLower(labels[j])
-		if !y5AllowedClasses[label] {
+		if !classes.Allowed[j] {
 			continue
 		}
 		if score > classConfidence {
@@ -294,7 +548,7 @@ func extractDetections(idx int, frame gocv.Mat, labels []string, data []float32,
 	// Ignore if the class is not important to us or the object and class confidences are low
 	if classID == -1 ||
 		objectConfidence < objectConfidenceThresh ||
-		finalConf < confidenceThresh {
+		finalConf < classes.Thresholds[classID] {
 		return detections
 	}
 
@@ -302,10 +556,6 @@ func extractDetections(idx int, frame gocv.Mat, labels []string, data []float32,
 		logRows("camera", "post", fmt.Sprintf("Row %d confidence: %f, class max score: %f (%s), finalConf: %f, class ID: %d\n", idx, objectConfidence, classConfidence, labels[classID], finalConf, classID))
 	}
 
-	if !y5AllowedClasses[strings.ToLower(labels[classID])] {
-		return detections
-	}
-
 	cx := data[0] * float32(frame.Cols())
 	cy := data[1] * float32(frame.Rows())
 	w := data[2] * float32(frame.Cols())
@@ -344,11 +594,11 @@ func logRows(camera, direction, message string) {
 	}
 }
 
-func logDetections(cameraName string, detections []y5Detection) {
+func logDetections(cameraName string, detections []y5Detection, params config.StreamerParameters) {
 	// Filter allowed classes
 	filtered := []y5Detection{}
 	for _, d := range detections {
-		if y5AllowedClasses[strings.ToLower(d.Label)] {
+		if classAllowed(params, d.Label) {
 			filtered = append(filtered, d)
 		}
 	}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+func TestOrderResults(t *testing.T) {
+	results := make(chan y5Result, 10)
+	for _, seq := range []int64{2, 0, 3, 1, 5, 4} {
+		results <- y5Result{Seq: seq}
+	}
+	close(results)
+
+	handled := []int64{}
+	orderResults(results, func(result y5Result) {
+		handled = append(handled, result.Seq)
+	})
+
+	if len(handled) != 6 {
+		t.Fatalf("expected 6 results, got %v", handled)
+	}
+	for i, seq := range handled {
+		if seq != int64(i) {
+			t.Fatalf("results are not in order: %v", handled)
+		}
+	}
+}
+
+func TestOrderResultsHoldsBackGaps(t *testing.T) {
+	results := make(chan y5Result, 10)
+	handled := []int64{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		orderResults(results, func(result y5Result) {
+			handled = append(handled, result.Seq)
+		})
+	}()
+
+	// The result of frame 1 never arrives so frame 2 is held back
+	results <- y5Result{Seq: 0}
+	results <- y5Result{Seq: 2}
+	close(results)
+	<-done
+
+	if len(handled) != 1 || handled[0] != 0 {
+		t.Fatalf("expected only frame 0 to be handled, got %v", handled)
+	}
+}
+
+func TestClassAllowed(t *testing.T) {
+	tests := []struct {
+		classes []string
+		label   string
+		allowed bool
+	}{
+		{nil, "person", true},
+		{nil, "car", false},
+		{[]string{}, "car", false},
+		{[]string{"car", "truck"}, "Truck", true},
+		{[]string{"car", "truck"}, "person", false},
+		{[]string{config.ClassAll}, "car", true},
+		{[]string{"ALL"}, "car", true},
+	}
+
+	for _, test := range tests {
+		params := config.StreamerParameters{}
+		params.Classes = test.classes
+		if allowed := classAllowed(params, test.label); allowed != test.allowed {
+			t.Fatalf("classes %v: expected %s allowed to be %v", test.classes, test.label, test.allowed)
+		}
+	}
+}
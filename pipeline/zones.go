@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"image"
+
+	"github.com/khaledhikmat/vs-go/model"
+)
+
+// frameZone is a camera zone scaled to the frame size
+type frameZone struct {
+	Name    string
+	Trigger string
+	Polygon []image.Point
+}
+
+// frameZones scales the camera zones (whose points are normalized) to the frame size
+func frameZones(zones []model.Zone, width, height int) []frameZone {
+	scaled := make([]frameZone, 0, len(zones))
+	for _, zone := range zones {
+		polygon := make([]image.Point, len(zone.Points))
+		for i, point := range zone.Points {
+			polygon[i] = image.Pt(int(point.X*float64(width)), int(point.Y*float64(height)))
+		}
+
+		scaled = append(scaled, frameZone{
+			Name:    zone.Name,
+			Trigger: zone.Trigger,
+			Polygon: polygon,
+		})
+	}
+
+	return scaled
+}
+
+// boxZones returns the names of the zones that the object box is in
+func boxZones(zones []frameZone, box image.Rectangle) []string {
+	names := []string{}
+	for _, zone := range zones {
+		if zone.contains(box) {
+			names = append(names, zone.Name)
+		}
+	}
+
+	return names
+}
+
+// contains tells whether the object box is in the zone according to the zone trigger
+func (z frameZone) contains(box image.Rectangle) bool {
+	if z.Trigger == model.ZoneTriggerBox {
+		return polygonOverlaps(z.Polygon, box)
+	}
+
+	return polygonContains(z.Polygon, footPoint(box))
+}
+
+// footPoint is the bottom center of the box i.e. where a person stands
+func footPoint(box image.Rectangle) image.Point {
+	return image.Pt((box.Min.X+box.Max.X)/2, box.Max.Y)
+}
+
+// polygonContains tells whether the point is inside the polygon (ray casting)
+func polygonContains(polygon []image.Point, p image.Point) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Y > p.Y) == (b.Y > p.Y) {
+			continue
+		}
+
+		// X of the edge where it crosses the horizontal line of the point
+		x := float64(a.X) + float64(p.Y-a.Y)*float64(b.X-a.X)/float64(b.Y-a.Y)
+		if float64(p.X) < x {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// polygonOverlaps tells whether any part of the box is inside the polygon
+func polygonOverlaps(polygon []image.Point, box image.Rectangle) bool {
+	corners := []image.Point{
+		box.Min,
+		image.Pt(box.Max.X, box.Min.Y),
+		box.Max,
+		image.Pt(box.Min.X, box.Max.Y),
+	}
+
+	// A box corner inside the polygon
+	for _, corner := range corners {
+		if polygonContains(polygon, corner) {
+			return true
+		}
+	}
+
+	// A polygon vertex inside the box
+	for _, vertex := range polygon {
+		if vertex.In(box) {
+			return true
+		}
+	}
+
+	// Otherwise they only overlap if their edges cross
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		for k := range corners {
+			if segmentsCross(polygon[j], polygon[i], corners[k], corners[(k+1)%len(corners)]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// segmentsCross tells whether the segments p1-p2 and p3-p4 properly cross each other
+func segmentsCross(p1, p2, p3, p4 image.Point) bool {
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) &&
+		((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// orientation is the sign of the cross product of a-b and a-c
+func orientation(a, b, c image.Point) int {
+	cross := (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+	switch {
+	case cross > 0:
+		return 1
+	case cross < 0:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,684 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/khaledhikmat/vs-go/service/lgr"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// How often the config file is checked for changes
+	reloadInterval = 5 * time.Second
+)
+
+// Environment variables that override the values loaded from the config file
+const (
+	envModeMaxShutdownTime             = "MODE_MAX_SHUTDOWN_TIME"
+	envInputFolder                     = "INPUT_FOLDER"
+	envCamerasInputFile                = "CAMERAS_INPUT_FILE"
+	envRecordingsFolder                = "RECORDINGS_FOLDER"
+	envMaxAgentsPerPod                 = "MAX_AGENTS_PER_POD"
+	envAgentAlerterPeriodicTimeout     = "AGENT_ALERTER_PERIODIC_TIMEOUT"
+	envAgentPeriodicTimeout            = "AGENT_PERIODIC_TIMEOUT"
+	envAgentLeaseDuration              = "AGENT_LEASE_DURATION"
+	envAgentMaxRestarts                = "AGENT_MAX_RESTARTS"
+	envAgentRestartBackoff             = "AGENT_RESTART_BACKOFF"
+	envAgentRestartMaxBackoff          = "AGENT_RESTART_MAX_BACKOFF"
+	envAgentAbandonCooldown            = "AGENT_ABANDON_COOLDOWN"
+	envAgentsManagerPeriodicTimeout    = "AGENTS_MANAGER_PERIODIC_TIMEOUT"
+	envAgentsMonitorPeriodicTimeout    = "AGENTS_MONITOR_PERIODIC_TIMEOUT"
+	envAgentsMonitorMaxOrphanedCameras = "AGENTS_MONITOR_MAX_ORPHANED_CAMERAS"
+	envStreamerMaxWorkers              = "STREAMER_MAX_WORKERS"
+	envFramerMaxReadFailures           = "FRAMER_MAX_READ_FAILURES"
+	envFramerStallTimeout              = "FRAMER_STALL_TIMEOUT"
+	envFramerReconnectBackoff          = "FRAMER_RECONNECT_BACKOFF"
+	envFramerReconnectMaxBackoff       = "FRAMER_RECONNECT_MAX_BACKOFF"
+	envFrameDebug                      = "FRAME_DEBUG"
+)
+
+// fileSettings is the layout of the YAML or TOML config file.
+// Any value that is not present in the file keeps its hard-coded default.
+type fileSettings struct {
+	ModeMaxShutdownTime             int                           `yaml:"modeMaxShutdownTime" toml:"modeMaxShutdownTime"`
+	InputFolder                     string                        `yaml:"inputFolder" toml:"inputFolder"`
+	CamerasInputFile                string                        `yaml:"camerasInputFile" toml:"camerasInputFile"`
+	RecordingsFolder                string                        `yaml:"recordingsFolder" toml:"recordingsFolder"`
+	MaxAgentsPerPod                 int                           `yaml:"maxAgentsPerPod" toml:"maxAgentsPerPod"`
+	AgentAlerterPeriodicTimeout     int                           `yaml:"agentAlerterPeriodicTimeout" toml:"agentAlerterPeriodicTimeout"`
+	AgentPeriodicTimeout            int                           `yaml:"agentPeriodicTimeout" toml:"agentPeriodicTimeout"`
+	AgentLeaseDuration              int                           `yaml:"agentLeaseDuration" toml:"agentLeaseDuration"`
+	AgentMaxRestarts                int                           `yaml:"agentMaxRestarts" toml:"agentMaxRestarts"`
+	AgentRestartBackoff             int                           `yaml:"agentRestartBackoff" toml:"agentRestartBackoff"`
+	AgentRestartMaxBackoff          int                           `yaml:"agentRestartMaxBackoff" toml:"agentRestartMaxBackoff"`
+	AgentAbandonCooldown            int                           `yaml:"agentAbandonCooldown" toml:"agentAbandonCooldown"`
+	AgentsManagerPeriodicTimeout    int                           `yaml:"agentsManagerPeriodicTimeout" toml:"agentsManagerPeriodicTimeout"`
+	AgentsMonitorPeriodicTimeout    int                           `yaml:"agentsMonitorPeriodicTimeout" toml:"agentsMonitorPeriodicTimeout"`
+	AgentsMonitorMaxOrphanedCameras int                           `yaml:"agentsMonitorMaxOrphanedCameras" toml:"agentsMonitorMaxOrphanedCameras"`
+	StreamerMaxWorkers              int                           `yaml:"streamerMaxWorkers" toml:"streamerMaxWorkers"`
+	FramerMaxReadFailures           int                           `yaml:"framerMaxReadFailures" toml:"framerMaxReadFailures"`
+	FramerStallTimeout              int                           `yaml:"framerStallTimeout" toml:"framerStallTimeout"`
+	FramerReconnectBackoff          int                           `yaml:"framerReconnectBackoff" toml:"framerReconnectBackoff"`
+	FramerReconnectMaxBackoff       int                           `yaml:"framerReconnectMaxBackoff" toml:"framerReconnectMaxBackoff"`
+	FrameDebug                      bool                          `yaml:"frameDebug" toml:"frameDebug"`
+	Streamers                       map[string]StreamerParameters `yaml:"-" toml:"-"`
+	// Streamer parameters as found in the file. They are merged over the defaults field by field.
+	StreamerValues map[string]map[string]interface{} `yaml:"streamers" toml:"streamers"`
+}
+
+type fileService struct {
+	CanxCtx     context.Context
+	Path        string
+	Settings    fileSettings
+	ModTime     time.Time
+	Size        int64
+	Mutex       sync.RWMutex
+	Subscribers []chan struct{}
+}
+
+// NewFile loads the configuration from a YAML (.yaml, .yml) or TOML (.toml) file.
+// Environment variables override the file values and the result is validated
+// so that a bad configuration is reported at startup rather than at runtime.
+// The file is then watched until the context is cancelled: a valid change is
+// applied and published to watchers while an invalid one is reported and ignored.
+func NewFile(canxCtx context.Context, path string) (IService, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := &fileService{
+		CanxCtx:  canxCtx,
+		Path:     path,
+		Settings: settings,
+		ModTime:  info.ModTime(),
+		Size:     info.Size(),
+	}
+
+	go svc.watchFile()
+
+	return svc, nil
+}
+
+func (svc *fileService) GetModeMaxShutdownTime() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.ModeMaxShutdownTime
+}
+
+func (svc *fileService) GetInputFolder() string {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.InputFolder
+}
+
+func (svc *fileService) GetCamerasInputFile() string {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.CamerasInputFile
+}
+
+func (svc *fileService) GetRecordingsFolder() string {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.RecordingsFolder
+}
+
+func (svc *fileService) GetMaxAgentsPerPod() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.MaxAgentsPerPod
+}
+
+func (svc *fileService) GetAgentAlerterPeriodicTimeout() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentAlerterPeriodicTimeout
+}
+
+func (svc *fileService) GetAgentPeriodicTimeout() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentPeriodicTimeout
+}
+
+func (svc *fileService) GetAgentLeaseDuration() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentLeaseDuration
+}
+
+func (svc *fileService) GetAgentMaxRestarts() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentMaxRestarts
+}
+
+func (svc *fileService) GetAgentRestartBackoff() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentRestartBackoff
+}
+
+func (svc *fileService) GetAgentRestartMaxBackoff() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentRestartMaxBackoff
+}
+
+func (svc *fileService) GetAgentAbandonCooldown() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentAbandonCooldown
+}
+
+func (svc *fileService) GetAgentsManagerPeriodicTimeout() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentsManagerPeriodicTimeout
+}
+
+func (svc *fileService) GetAgentsMonitorPeriodicTimeout() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentsMonitorPeriodicTimeout
+}
+
+func (svc *fileService) GetAgentsMonitorMaxOrphanedCameras() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.AgentsMonitorMaxOrphanedCameras
+}
+
+func (svc *fileService) GetStreamerMaxWorkers() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.StreamerMaxWorkers
+}
+
+func (svc *fileService) GetFramerMaxReadFailures() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.FramerMaxReadFailures
+}
+
+func (svc *fileService) GetFramerStallTimeout() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.FramerStallTimeout
+}
+
+func (svc *fileService) GetFramerReconnectBackoff() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.FramerReconnectBackoff
+}
+
+func (svc *fileService) GetFramerReconnectMaxBackoff() int {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.FramerReconnectMaxBackoff
+}
+
+func (svc *fileService) GetFrameDebug() bool {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.FrameDebug
+}
+
+func (svc *fileService) GetStreamerParameters(name string) StreamerParameters {
+	svc.Mutex.RLock()
+	defer svc.Mutex.RUnlock()
+	return svc.Settings.Streamers[name]
+}
+
+func (svc *fileService) Watch(canxCtx context.Context) <-chan struct{} {
+	// Buffer one signal so that a slow watcher still sees the latest change
+	// without blocking the file watcher
+	changes := make(chan struct{}, 1)
+
+	svc.Mutex.Lock()
+	svc.Subscribers = append(svc.Subscribers, changes)
+	svc.Mutex.Unlock()
+
+	go func() {
+		<-canxCtx.Done()
+
+		svc.Mutex.Lock()
+		defer svc.Mutex.Unlock()
+		for i, subscriber := range svc.Subscribers {
+			if subscriber == changes {
+				svc.Subscribers = append(svc.Subscribers[:i], svc.Subscribers[i+1:]...)
+				break
+			}
+		}
+		close(changes)
+	}()
+
+	return changes
+}
+
+// watchFile polls the config file until the context is cancelled
+func (svc *fileService) watchFile() {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-svc.CanxCtx.Done():
+			lgr.Logger.Info(
+				"config file watcher context cancelled",
+			)
+			return
+
+		case <-ticker.C:
+			svc.reload()
+		}
+	}
+}
+
+// reload loads the config file if its modification time or size changed and publishes the new settings
+func (svc *fileService) reload() {
+	info, err := os.Stat(svc.Path)
+	if err != nil {
+		lgr.Logger.Error(
+			"error checking config file",
+			slog.String("file", svc.Path),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	if info.ModTime().Equal(svc.ModTime) && info.Size() == svc.Size {
+		return
+	}
+
+	svc.ModTime = info.ModTime()
+	svc.Size = info.Size()
+
+	settings, err := loadSettings(svc.Path)
+	if err != nil {
+		// Keep running with the previous settings
+		lgr.Logger.Error(
+			"error reloading config file. Keeping previous configuration",
+			slog.String("file", svc.Path),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	svc.Mutex.Lock()
+	svc.Settings = settings
+	for _, subscriber := range svc.Subscribers {
+		select {
+		case subscriber <- struct{}{}:
+		default:
+			// A change is already pending for this subscriber
+		}
+	}
+	svc.Mutex.Unlock()
+
+	lgr.Logger.Info(
+		"config file reloaded",
+		slog.String("file", svc.Path),
+	)
+}
+
+// defaultSettings returns the hard-coded configuration so that a config file
+// only needs to carry the values it wants to change
+func defaultSettings() fileSettings {
+	hc := NewHardCoded()
+	return fileSettings{
+		ModeMaxShutdownTime:             hc.GetModeMaxShutdownTime(),
+		InputFolder:                     hc.GetInputFolder(),
+		RecordingsFolder:                hc.GetRecordingsFolder(),
+		MaxAgentsPerPod:                 hc.GetMaxAgentsPerPod(),
+		AgentAlerterPeriodicTimeout:     hc.GetAgentAlerterPeriodicTimeout(),
+		AgentPeriodicTimeout:            hc.GetAgentPeriodicTimeout(),
+		AgentLeaseDuration:              hc.GetAgentLeaseDuration(),
+		AgentMaxRestarts:                hc.GetAgentMaxRestarts(),
+		AgentRestartBackoff:             hc.GetAgentRestartBackoff(),
+		AgentRestartMaxBackoff:          hc.GetAgentRestartMaxBackoff(),
+		AgentAbandonCooldown:            hc.GetAgentAbandonCooldown(),
+		AgentsManagerPeriodicTimeout:    hc.GetAgentsManagerPeriodicTimeout(),
+		AgentsMonitorPeriodicTimeout:    hc.GetAgentsMonitorPeriodicTimeout(),
+		AgentsMonitorMaxOrphanedCameras: hc.GetAgentsMonitorMaxOrphanedCameras(),
+		StreamerMaxWorkers:              hc.GetStreamerMaxWorkers(),
+		FramerMaxReadFailures:           hc.GetFramerMaxReadFailures(),
+		FramerStallTimeout:              hc.GetFramerStallTimeout(),
+		FramerReconnectBackoff:          hc.GetFramerReconnectBackoff(),
+		FramerReconnectMaxBackoff:       hc.GetFramerReconnectMaxBackoff(),
+		FrameDebug:                      hc.GetFrameDebug(),
+		Streamers: map[string]StreamerParameters{
+			MP4RecorderName:       hc.GetStreamerParameters(MP4RecorderName),
+			SimpleDetectorName:    hc.GetStreamerParameters(SimpleDetectorName),
+			Yolo5DetectorName:     hc.GetStreamerParameters(Yolo5DetectorName),
+			MotionDetectorName:    hc.GetStreamerParameters(MotionDetectorName),
+			LineCounterName:       hc.GetStreamerParameters(LineCounterName),
+			LoiteringDetectorName: hc.GetStreamerParameters(LoiteringDetectorName),
+		},
+	}
+}
+
+func loadSettings(path string) (fileSettings, error) {
+	settings := defaultSettings()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return settings, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		// Strict mode rejects unknown or duplicate keys which are most likely typos
+		err = yaml.UnmarshalStrict(data, &settings)
+		if err != nil {
+			return settings, fmt.Errorf("error parsing yaml config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &settings)
+		if err != nil {
+			return settings, fmt.Errorf("error parsing toml config file %s: %w", path, err)
+		}
+
+		// Streamer keys are checked when the streamer parameters are merged
+		undecoded := []toml.Key{}
+		for _, key := range md.Undecoded() {
+			if key[0] != "streamers" {
+				undecoded = append(undecoded, key)
+			}
+		}
+
+		if len(undecoded) > 0 {
+			return settings, fmt.Errorf("error parsing toml config file %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return settings, fmt.Errorf("unsupported config file extension %q: use .yaml, .yml or .toml", ext)
+	}
+
+	// Streamer parameters missing from the file keep their defaults
+	for name, values := range settings.StreamerValues {
+		params, err := mergeStreamerParameters(settings.Streamers[name], stringKeys(values).(map[string]interface{}))
+		if err != nil {
+			return settings, fmt.Errorf("error parsing config file %s: invalid streamers.%s: %w", path, name, err)
+		}
+		settings.Streamers[name] = params
+	}
+	settings.StreamerValues = nil
+
+	if settings.CamerasInputFile == "" {
+		settings.CamerasInputFile = fmt.Sprintf("%s/cameras.json", settings.InputFolder)
+	}
+
+	err = applyEnvOverrides(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	err = validateSettings(settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+func applyEnvOverrides(settings *fileSettings) error {
+	ints := map[string]*int{
+		envModeMaxShutdownTime:             &settings.ModeMaxShutdownTime,
+		envMaxAgentsPerPod:                 &settings.MaxAgentsPerPod,
+		envAgentAlerterPeriodicTimeout:     &settings.AgentAlerterPeriodicTimeout,
+		envAgentPeriodicTimeout:            &settings.AgentPeriodicTimeout,
+		envAgentLeaseDuration:              &settings.AgentLeaseDuration,
+		envAgentMaxRestarts:                &settings.AgentMaxRestarts,
+		envAgentRestartBackoff:             &settings.AgentRestartBackoff,
+		envAgentRestartMaxBackoff:          &settings.AgentRestartMaxBackoff,
+		envAgentAbandonCooldown:            &settings.AgentAbandonCooldown,
+		envAgentsManagerPeriodicTimeout:    &settings.AgentsManagerPeriodicTimeout,
+		envAgentsMonitorPeriodicTimeout:    &settings.AgentsMonitorPeriodicTimeout,
+		envAgentsMonitorMaxOrphanedCameras: &settings.AgentsMonitorMaxOrphanedCameras,
+		envStreamerMaxWorkers:              &settings.StreamerMaxWorkers,
+		envFramerMaxReadFailures:           &settings.FramerMaxReadFailures,
+		envFramerStallTimeout:              &settings.FramerStallTimeout,
+		envFramerReconnectBackoff:          &settings.FramerReconnectBackoff,
+		envFramerReconnectMaxBackoff:       &settings.FramerReconnectMaxBackoff,
+	}
+
+	strs := map[string]*string{
+		envInputFolder:      &settings.InputFolder,
+		envCamerasInputFile: &settings.CamerasInputFile,
+		envRecordingsFolder: &settings.RecordingsFolder,
+	}
+
+	bools := map[string]*bool{
+		envFrameDebug: &settings.FrameDebug,
+	}
+
+	for key, field := range ints {
+		val, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+
+		i, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("invalid value %q for env var %s: %w", val, key, err)
+		}
+
+		*field = i
+	}
+
+	for key, field := range strs {
+		val, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+
+		*field = strings.TrimSpace(val)
+	}
+
+	for key, field := range bools {
+		val, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("invalid value %q for env var %s: %w", val, key, err)
+		}
+
+		*field = b
+	}
+
+	return nil
+}
+
+func validateSettings(settings fileSettings) error {
+	var errs []error
+
+	positives := []struct {
+		name  string
+		value int
+	}{
+		{"modeMaxShutdownTime", settings.ModeMaxShutdownTime},
+		{"maxAgentsPerPod", settings.MaxAgentsPerPod},
+		{"agentAlerterPeriodicTimeout", settings.AgentAlerterPeriodicTimeout},
+		{"agentPeriodicTimeout", settings.AgentPeriodicTimeout},
+		{"agentLeaseDuration", settings.AgentLeaseDuration},
+		{"agentMaxRestarts", settings.AgentMaxRestarts},
+		{"agentRestartBackoff", settings.AgentRestartBackoff},
+		{"agentRestartMaxBackoff", settings.AgentRestartMaxBackoff},
+		{"agentAbandonCooldown", settings.AgentAbandonCooldown},
+		{"agentsManagerPeriodicTimeout", settings.AgentsManagerPeriodicTimeout},
+		{"agentsMonitorPeriodicTimeout", settings.AgentsMonitorPeriodicTimeout},
+		{"agentsMonitorMaxOrphanedCameras", settings.AgentsMonitorMaxOrphanedCameras},
+		{"streamerMaxWorkers", settings.StreamerMaxWorkers},
+		{"framerMaxReadFailures", settings.FramerMaxReadFailures},
+		{"framerStallTimeout", settings.FramerStallTimeout},
+		{"framerReconnectBackoff", settings.FramerReconnectBackoff},
+		{"framerReconnectMaxBackoff", settings.FramerReconnectMaxBackoff},
+	}
+
+	for _, p := range positives {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be greater than zero, got %d", p.name, p.value))
+		}
+	}
+
+	// Agents renew their lease every agent periodic timeout so the lease must outlive it
+	if settings.AgentLeaseDuration <= settings.AgentPeriodicTimeout {
+		errs = append(errs, fmt.Errorf("agentLeaseDuration (%d) must be greater than agentPeriodicTimeout (%d)", settings.AgentLeaseDuration, settings.AgentPeriodicTimeout))
+	}
+
+	if settings.AgentRestartMaxBackoff < settings.AgentRestartBackoff {
+		errs = append(errs, fmt.Errorf("agentRestartMaxBackoff (%d) must not be less than agentRestartBackoff (%d)", settings.AgentRestartMaxBackoff, settings.AgentRestartBackoff))
+	}
+
+	if settings.FramerReconnectMaxBackoff < settings.FramerReconnectBackoff {
+		errs = append(errs, fmt.Errorf("framerReconnectMaxBackoff (%d) must not be less than framerReconnectBackoff (%d)", settings.FramerReconnectMaxBackoff, settings.FramerReconnectBackoff))
+	}
+
+	if settings.InputFolder == "" {
+		errs = append(errs, errors.New("inputFolder must not be empty"))
+	} else if info, err := os.Stat(settings.InputFolder); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("inputFolder %s is not an existing folder", settings.InputFolder))
+	}
+
+	if settings.RecordingsFolder == "" {
+		errs = append(errs, errors.New("recordingsFolder must not be empty"))
+	}
+
+	for name, params := range settings.Streamers {
+		errs = append(errs, validateStreamerParameters(name, params)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateStreamerParameters(name string, params StreamerParameters) []error {
+	var errs []error
+
+	if params.ClipDuration < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.clipDuration must not be negative, got %d", name, params.ClipDuration))
+	}
+
+	if name == Yolo5DetectorName && (params.ModelPath == "" || params.CocoNamesPath == "") {
+		errs = append(errs, fmt.Errorf("streamers.%s.modelPath and cocoNamesPath must not be empty", name))
+	}
+
+	if params.CoolDownPeriod < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.coolDownPeriod must not be negative, got %d", name, params.CoolDownPeriod))
+	}
+
+	if params.ConfidenceThreshold < 0 || params.ConfidenceThreshold > 1 {
+		errs = append(errs, fmt.Errorf("streamers.%s.confidenceThreshold must be between 0 and 1, got %f", name, params.ConfidenceThreshold))
+	}
+
+	if params.ObjectConfidenceThreshold < 0 || params.ObjectConfidenceThreshold > 1 {
+		errs = append(errs, fmt.Errorf("streamers.%s.objectConfidenceThreshold must be between 0 and 1, got %f", name, params.ObjectConfidenceThreshold))
+	}
+
+	switch params.Sampling {
+	case "", SamplingAll, SamplingKeyFrames:
+	case SamplingEveryNth:
+		if params.SampleEvery < 1 {
+			errs = append(errs, fmt.Errorf("streamers.%s.sampleEvery must be at least 1 when sampling is %s, got %d", name, SamplingEveryNth, params.SampleEvery))
+		}
+	case SamplingMaxFPS:
+		if params.MaxFPS <= 0 {
+			errs = append(errs, fmt.Errorf("streamers.%s.maxFps must be positive when sampling is %s, got %f", name, SamplingMaxFPS, params.MaxFPS))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("streamers.%s.sampling must be one of %s, %s, %s or %s, got %q", name, SamplingAll, SamplingEveryNth, SamplingMaxFPS, SamplingKeyFrames, params.Sampling))
+	}
+
+	switch params.Backpressure {
+	case "", BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest, BackpressureLatest:
+	default:
+		errs = append(errs, fmt.Errorf("streamers.%s.backpressure must be one of %s, %s, %s or %s, got %q", name, BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest, BackpressureLatest, params.Backpressure))
+	}
+
+	if params.Subtractor != "" && params.Subtractor != SubtractorMOG2 && params.Subtractor != SubtractorKNN {
+		errs = append(errs, fmt.Errorf("streamers.%s.subtractor must be %s or %s, got %q", name, SubtractorMOG2, SubtractorKNN, params.Subtractor))
+	}
+
+	if params.Sensitivity < 0 || params.Sensitivity > 1 {
+		errs = append(errs, fmt.Errorf("streamers.%s.sensitivity must be between 0 and 1, got %f", name, params.Sensitivity))
+	}
+
+	if params.MinBlobArea < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.minBlobArea must not be negative, got %d", name, params.MinBlobArea))
+	}
+
+	if params.WarmupFrames < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.warmupFrames must not be negative, got %d", name, params.WarmupFrames))
+	}
+
+	if params.MotionHoldPeriod < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.motionHoldPeriod must not be negative, got %d", name, params.MotionHoldPeriod))
+	}
+
+	if params.TrackIoUThreshold < 0 || params.TrackIoUThreshold > 1 {
+		errs = append(errs, fmt.Errorf("streamers.%s.trackIouThreshold must be between 0 and 1, got %f", name, params.TrackIoUThreshold))
+	}
+
+	if params.TrackMinHits < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.trackMinHits must not be negative, got %d", name, params.TrackMinHits))
+	}
+
+	if params.TrackMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.trackMaxAge must not be negative, got %d", name, params.TrackMaxAge))
+	}
+
+	if params.CountPeriod < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.countPeriod must not be negative, got %d", name, params.CountPeriod))
+	}
+
+	if params.CountThreshold < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.countThreshold must not be negative, got %d", name, params.CountThreshold))
+	}
+
+	if params.CountThreshold > 0 && params.CountWindow < 1 {
+		errs = append(errs, fmt.Errorf("streamers.%s.countWindow must be at least 1 when countThreshold is set, got %d", name, params.CountWindow))
+	}
+
+	if params.DwellThreshold < 0 {
+		errs = append(errs, fmt.Errorf("streamers.%s.dwellThreshold must not be negative, got %d", name, params.DwellThreshold))
+	}
+
+	for _, class := range params.Classes {
+		if strings.TrimSpace(class) == "" {
+			errs = append(errs, fmt.Errorf("streamers.%s.classes must not have empty classes", name))
+		}
+	}
+
+	for class, threshold := range params.ClassConfidenceThresholds {
+		if threshold < 0 || threshold > 1 {
+			errs = append(errs, fmt.Errorf("streamers.%s.classConfidenceThresholds.%s must be between 0 and 1, got %f", name, class, threshold))
+		}
+	}
+
+	for class, period := range params.ClassCoolDownPeriods {
+		if period < 0 {
+			errs = append(errs, fmt.Errorf("streamers.%s.classCoolDownPeriods.%s must not be negative, got %d", name, class, period))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file in a temp folder. The input folder must exist so it points to the temp folder.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	content = strings.ReplaceAll(content, "$INPUT", filepath.ToSlash(dir))
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+inputFolder: $INPUT
+maxAgentsPerPod: 3
+agentLeaseDuration: 120
+streamers:
+  yolo5Detector:
+    confidenceThreshold: 0.7
+    classes: [car, truck]
+    classConfidenceThresholds:
+      truck: 0.5
+`)
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		t.Fatalf("error loading yaml config: %v", err)
+	}
+
+	if settings.MaxAgentsPerPod != 3 || settings.AgentLeaseDuration != 120 {
+		t.Fatalf("file values were not loaded: %+v", settings)
+	}
+
+	// Values left out keep their defaults
+	hc := NewHardCoded()
+	if settings.AgentPeriodicTimeout != hc.GetAgentPeriodicTimeout() || settings.RecordingsFolder != hc.GetRecordingsFolder() {
+		t.Fatalf("missing values did not keep their defaults: %+v", settings)
+	}
+	if settings.CamerasInputFile != fmt.Sprintf("%s/cameras.json", settings.InputFolder) {
+		t.Fatalf("cameras input file is not in the input folder: %s", settings.CamerasInputFile)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeConfig(t, "config.toml", `
+inputFolder = "$INPUT"
+maxAgentsPerPod = 4
+frameDebug = true
+
+[streamers.motionDetector]
+subtractor = "knn"
+minBlobArea = 200
+`)
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		t.Fatalf("error loading toml config: %v", err)
+	}
+
+	if settings.MaxAgentsPerPod != 4 || !settings.FrameDebug {
+		t.Fatalf("file values were not loaded: %+v", settings)
+	}
+
+	params := settings.Streamers[MotionDetectorName]
+	if params.Subtractor != SubtractorKNN || params.MinBlobArea != 200 {
+		t.Fatalf("streamer values were not loaded: %+v", params)
+	}
+}
+
+func TestPartialStreamerParametersMergeOverDefaults(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+inputFolder: $INPUT
+streamers:
+  yolo5Detector:
+    confidenceThreshold: 0.7
+    classConfidenceThresholds:
+      truck: 0.5
+`)
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	defaults := NewHardCoded().GetStreamerParameters(Yolo5DetectorName)
+	params := settings.Streamers[Yolo5DetectorName]
+	if params.ConfidenceThreshold != 0.7 || params.ClassConfidenceThresholds["truck"] != 0.5 {
+		t.Fatalf("file values were not merged: %+v", params)
+	}
+	if params.ModelPath != defaults.ModelPath || params.Sampling != defaults.Sampling || params.TrackMinHits != defaults.TrackMinHits {
+		t.Fatalf("missing values did not keep their defaults: %+v", params)
+	}
+	if len(params.Classes) != 1 || params.Classes[0] != DefaultClass {
+		t.Fatalf("missing classes did not keep their defaults: %v", params.Classes)
+	}
+
+	// Streamers left out of the file keep all their defaults
+	if settings.Streamers[MotionDetectorName].Subtractor != NewHardCoded().GetStreamerParameters(MotionDetectorName).Subtractor {
+		t.Fatalf("missing streamer did not keep its defaults: %+v", settings.Streamers[MotionDetectorName])
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+inputFolder: $INPUT
+maxAgentsPerPod: 3
+recordingsFolder: ./from-file
+`)
+
+	t.Setenv(envMaxAgentsPerPod, "7")
+	t.Setenv(envRecordingsFolder, " ./from-env ")
+	t.Setenv(envFrameDebug, "true")
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	if settings.MaxAgentsPerPod != 7 || settings.RecordingsFolder != "./from-env" || !settings.FrameDebug {
+		t.Fatalf("env vars did not override the file: %+v", settings)
+	}
+
+	t.Setenv(envMaxAgentsPerPod, "many")
+	_, err = loadSettings(path)
+	if err == nil || !strings.Contains(err.Error(), envMaxAgentsPerPod) {
+		t.Fatalf("expected an invalid env var error, got %v", err)
+	}
+}
+
+func TestInvalidConfigIsRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     string
+	}{
+		{
+			name:    "unknown yaml key",
+			file:    "config.yaml",
+			content: "inputFolder: $INPUT\nmaxAgentPerPod: 3\n",
+			err:     "maxAgentPerPod",
+		},
+		{
+			name:    "unknown toml key",
+			file:    "config.toml",
+			content: "inputFolder = \"$INPUT\"\nmaxAgentPerPod = 3\n",
+			err:     "maxAgentPerPod",
+		},
+		{
+			name:    "unknown streamer key",
+			file:    "config.yaml",
+			content: "inputFolder: $INPUT\nstreamers:\n  yolo5Detector:\n    confidenceThresold: 0.5\n",
+			err:     "confidenceThresold",
+		},
+		{
+			name:    "unknown toml streamer key",
+			file:    "config.toml",
+			content: "inputFolder = \"$INPUT\"\n[streamers.yolo5Detector]\nconfidenceThresold = 0.5\n",
+			err:     "confidenceThresold",
+		},
+		{
+			name:    "invalid value",
+			file:    "config.yaml",
+			content: "inputFolder: $INPUT\nmaxAgentsPerPod: 0\n",
+			err:     "maxAgentsPerPod must be greater than zero",
+		},
+		{
+			name:    "invalid streamer value",
+			file:    "config.yaml",
+			content: "inputFolder: $INPUT\nstreamers:\n  motionDetector:\n    subtractor: gmg\n",
+			err:     "streamers.motionDetector.subtractor",
+		},
+		{
+			name:    "lease shorter than the renewals",
+			file:    "config.yaml",
+			content: "inputFolder: $INPUT\nagentLeaseDuration: 30\nagentPeriodicTimeout: 30\n",
+			err:     "agentLeaseDuration (30) must be greater than agentPeriodicTimeout (30)",
+		},
+		{
+			name:    "missing input folder",
+			file:    "config.yaml",
+			content: "inputFolder: $INPUT/missing\n",
+			err:     "is not an existing folder",
+		},
+		{
+			name:    "unsupported extension",
+			file:    "config.json",
+			content: "{}",
+			err:     "unsupported config file extension",
+		},
+	}
+
+	for _, test := range tests {
+		path := writeConfig(t, test.file, test.content)
+		_, err := loadSettings(path)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestMergeStreamerParameters(t *testing.T) {
+	params := NewHardCoded().GetStreamerParameters(Yolo5DetectorName)
+	params.ClassCoolDownPeriods = map[string]int{"car": 30}
+
+	merged, err := mergeStreamerParameters(params, map[string]interface{}{
+		"coolDownPeriod":       15,
+		"classes":              []string{"car"},
+		"classCoolDownPeriods": map[string]int{"truck": 60},
+	})
+	if err != nil {
+		t.Fatalf("error merging parameters: %v", err)
+	}
+
+	if merged.CoolDownPeriod != 15 || merged.ModelPath != params.ModelPath || !merged.Tracking {
+		t.Fatalf("parameters were not merged field by field: %+v", merged)
+	}
+	// Maps are merged while slices are replaced
+	if merged.ClassCoolDownPeriods["car"] != 30 || merged.ClassCoolDownPeriods["truck"] != 60 {
+		t.Fatalf("class cool down periods were not merged: %v", merged.ClassCoolDownPeriods)
+	}
+	if len(merged.Classes) != 1 || merged.Classes[0] != "car" {
+		t.Fatalf("classes were not replaced: %v", merged.Classes)
+	}
+
+	// The original parameters are left alone
+	if len(params.ClassCoolDownPeriods) != 1 || params.Classes[0] != DefaultClass {
+		t.Fatalf("merge changed the original parameters: %+v", params)
+	}
+
+	_, err = mergeStreamerParameters(params, map[string]interface{}{"motion": map[string]interface{}{"subtractor": SubtractorKNN}})
+	if err == nil {
+		t.Fatalf("expected an unknown key error")
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -54,6 +55,36 @@ func (svc *hardcodedService) GetAgentPeriodicTimeout() int {
 	return 30
 }
 
+func (svc *hardcodedService) GetAgentLeaseDuration() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 5 * 60
+}
+
+func (svc *hardcodedService) GetAgentMaxRestarts() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 5
+}
+
+func (svc *hardcodedService) GetAgentRestartBackoff() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 2
+}
+
+func (svc *hardcodedService) GetAgentRestartMaxBackoff() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 60
+}
+
+func (svc *hardcodedService) GetAgentAbandonCooldown() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 10 * 60
+}
+
 func (svc *hardcodedService) GetAgentsManagerPeriodicTimeout() int {
 	// For now, we are using a hardcoded value.
 	// In the future, this should be read from a configuration file or environment variable.
@@ -77,6 +108,36 @@ func (svc *hardcodedService) GetStreamerMaxWorkers() int {
 	return 3
 }
 
+func (svc *hardcodedService) GetFramerMaxReadFailures() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 10
+}
+
+func (svc *hardcodedService) GetFramerStallTimeout() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 10
+}
+
+func (svc *hardcodedService) GetFramerReconnectBackoff() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 1
+}
+
+func (svc *hardcodedService) GetFramerReconnectMaxBackoff() int {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return 30
+}
+
+func (svc *hardcodedService) GetFrameDebug() bool {
+	// For now, we are using a hardcoded value.
+	// In the future, this should be read from a configuration file or environment variable.
+	return false
+}
+
 func (svc *hardcodedService) GetStreamerParameters(name string) StreamerParameters {
 	if name == "simpleDetector" {
 		return StreamerParameters{
@@ -86,6 +147,9 @@ func (svc *hardcodedService) GetStreamerParameters(name string) StreamerParamete
 			ConfidenceThreshold: 0,
 			CoolDownPeriod:      0,
 			Logging:             false,
+			Sampling:            SamplingEveryNth,
+			SampleEvery:         10,
+			Backpressure:        BackpressureDropOldest,
 		}
 	}
 
@@ -97,6 +161,8 @@ func (svc *hardcodedService) GetStreamerParameters(name string) StreamerParamete
 			ConfidenceThreshold: 0,
 			CoolDownPeriod:      0,
 			Logging:             false,
+			Sampling:            SamplingAll,
+			Backpressure:        BackpressureBlock,
 		}
 	}
 
@@ -108,8 +174,75 @@ func (svc *hardcodedService) GetStreamerParameters(name string) StreamerParamete
 			ConfidenceThreshold: 0.7,
 			CoolDownPeriod:      5,
 			Logging:             false,
+			Sampling:            SamplingEveryNth,
+			SampleEvery:         10,
+			Backpressure:        BackpressureLatest,
+			TrackingParameters: TrackingParameters{
+				Tracking:          true,
+				TrackIoUThreshold: 0.3,
+				TrackMinHits:      3,
+				TrackMaxAge:       2,
+			},
+			ClassParameters: ClassParameters{
+				Classes: []string{DefaultClass},
+			},
+		}
+	}
+
+	if name == "motionDetector" {
+		return StreamerParameters{
+			CoolDownPeriod: 10,
+			Sampling:       SamplingMaxFPS,
+			MaxFPS:         5,
+			Backpressure:   BackpressureLatest,
+			MotionParameters: MotionParameters{
+				Subtractor:       SubtractorMOG2,
+				Sensitivity:      0.5,
+				MinBlobArea:      500,
+				WarmupFrames:     25,
+				MotionGate:       false,
+				MotionHoldPeriod: 5,
+			},
+		}
+	}
+
+	if name == "lineCounter" {
+		// The counter works on the tracks of the YOLO detector and only keeps
+		// the latest frame for its alerts so it needs few frames
+		return StreamerParameters{
+			CoolDownPeriod: 60,
+			Sampling:       SamplingKeyFrames,
+			Backpressure:   BackpressureLatest,
+			CountParameters: CountParameters{
+				CountPeriod:    60,
+				CountThreshold: 0,
+				CountWindow:    60,
+			},
+		}
+	}
+
+	if name == "loiteringDetector" {
+		// Like the line counter, it works on the tracks of the YOLO detector
+		return StreamerParameters{
+			Sampling:     SamplingKeyFrames,
+			Backpressure: BackpressureLatest,
+			LoiteringParameters: LoiteringParameters{
+				DwellThreshold: 60,
+			},
 		}
 	}
 
 	return StreamerParameters{}
 }
+
+// The hard-coded configuration never changes so the watch channel is never signaled
+func (svc *hardcodedService) Watch(canxCtx context.Context) <-chan struct{} {
+	changes := make(chan struct{})
+
+	go func() {
+		<-canxCtx.Done()
+		close(changes)
+	}()
+
+	return changes
+}
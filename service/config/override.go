@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// OverrideStreamerParameters applies a camera's overrides on top of the configured streamer parameters.
+// Overrides are keyed by the JSON names of StreamerParameters (i.e. `confidenceThreshold`)
+// so a camera only needs to carry the values it wants to change.
+func OverrideStreamerParameters(name string, params StreamerParameters, overrides map[string]interface{}) (StreamerParameters, error) {
+	if len(overrides) == 0 {
+		return params, nil
+	}
+
+	overridden, err := mergeStreamerParameters(params, overrides)
+	if err != nil {
+		return params, fmt.Errorf("invalid %s parameter overrides: %w", name, err)
+	}
+
+	err = errors.Join(validateStreamerParameters(name, overridden)...)
+	if err != nil {
+		return params, err
+	}
+
+	return overridden, nil
+}
+
+// mergeStreamerParameters decodes the values (keyed by the JSON names of StreamerParameters)
+// on top of a copy of the parameters so that missing fields keep their values.
+// Unknown keys are rejected since they are most likely typos.
+func mergeStreamerParameters(params StreamerParameters, values map[string]interface{}) (StreamerParameters, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return params, err
+	}
+
+	merged := params
+	// The decoder writes into existing maps and slice arrays which are shared with the configuration.
+	// Map values are merged with the existing entries while slice values replace them.
+	merged.Classes = slices.Clone(params.Classes)
+	merged.ClassConfidenceThresholds = maps.Clone(params.ClassConfidenceThresholds)
+	merged.ClassCoolDownPeriods = maps.Clone(params.ClassCoolDownPeriods)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&merged)
+	if err != nil {
+		return params, err
+	}
+
+	return merged, nil
+}
+
+// stringKeys converts the maps decoded by the YAML parser (keyed by interface{}) to
+// maps keyed by strings so that the values can be marshalled to JSON
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			converted[fmt.Sprint(k)] = stringKeys(v)
+		}
+		return converted
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = stringKeys(v)
+		}
+		return value
+	case []interface{}:
+		for i, v := range value {
+			value[i] = stringKeys(v)
+		}
+		return value
+	default:
+		return value
+	}
+}
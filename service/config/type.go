@@ -1,22 +1,137 @@
 package config
 
+import "context"
+
 // Streamer names
 const (
-	MP4RecorderName    = "mp4Recorder"
-	SimpleDetectorName = "simpleDetector"
-	Yolo5DetectorName  = "yolo5Detector"
+	MP4RecorderName       = "mp4Recorder"
+	SimpleDetectorName    = "simpleDetector"
+	Yolo5DetectorName     = "yolo5Detector"
+	WebrtcBroadcasterName = "webrtcBroadcaster"
+	MotionDetectorName    = "motionDetector"
+	LineCounterName       = "lineCounter"
+	LoiteringDetectorName = "loiteringDetector"
+)
+
+// Alerter names
+const (
+	SimpleAlerterName = "simpleAlerter"
 )
 
+// Framer names (i.e. camera framer types)
+const (
+	RandomFramerName  = "random"
+	RTSPFramerName    = "rtsp"
+	ReplayFramerName  = "replay"
+	PatternFramerName = "pattern"
+)
+
+// Sampling policies of a streamer. The framer offers every frame to every streamer
+// and the policy decides which frames the streamer gets so that a recorder can get
+// the full-rate video while a detector gets a sample of it.
+const (
+	// SamplingAll routes every frame (the default)
+	SamplingAll = "all"
+	// SamplingEveryNth routes every `sampleEvery`th frame
+	SamplingEveryNth = "everyNth"
+	// SamplingMaxFPS routes frames at no more than `maxFps` frames per second
+	SamplingMaxFPS = "maxFps"
+	// SamplingKeyFrames routes the frames that the framer flags as key frames
+	SamplingKeyFrames = "keyFrames"
+)
+
+// Backpressure policies of a streamer i.e. what the framer does when the streamer's input is full
+const (
+	// BackpressureBlock waits for the streamer (the default). A slow streamer slows the framer
+	// and with it the other streamers of the camera.
+	BackpressureBlock = "block"
+	// BackpressureDropNewest drops the frame that does not fit
+	BackpressureDropNewest = "dropNewest"
+	// BackpressureDropOldest drops the oldest queued frame to make room for the new one
+	BackpressureDropOldest = "dropOldest"
+	// BackpressureLatest drops all queued frames so that the streamer always gets the latest one
+	BackpressureLatest = "latest"
+)
+
+// Background subtractors of the motion detector
+const (
+	SubtractorMOG2 = "mog2"
+	SubtractorKNN  = "knn"
+)
+
+// Classes of the YOLO detector. Without `classes`, it only detects the default class.
+// Every class is detected only when `classes` has `*` or `all`.
+const (
+	DefaultClass  = "person"
+	ClassAll      = "*"
+	ClassAllAlias = "all"
+)
+
+// StreamerParameters holds the parameters of all the streamers. The parameters of the
+// library streamers are grouped in embedded structs so that they are set flat in the
+// configuration (i.e. `subtractor` rather than `motion.subtractor`).
 type StreamerParameters struct {
-	ClipDuration              int     `yaml:"clipDuration"`
-	ModelPath                 string  `yaml:"modelPath"`
-	CocoNamesPath             string  `yaml:"cocoNamesPath"`
-	ObjectConfidenceThreshold float32 `yaml:"objectConfidenceThreshold"`
-	ConfidenceThreshold       float32 `yaml:"confidenceThreshold"`
-	CoolDownPeriod            int     `yaml:"coolDownPeriod"`
-	Logging                   bool    `yaml:"logging"`
+	ClipDuration              int     `yaml:"clipDuration" toml:"clipDuration" json:"clipDuration"`
+	ModelPath                 string  `yaml:"modelPath" toml:"modelPath" json:"modelPath"`
+	CocoNamesPath             string  `yaml:"cocoNamesPath" toml:"cocoNamesPath" json:"cocoNamesPath"`
+	ObjectConfidenceThreshold float32 `yaml:"objectConfidenceThreshold" toml:"objectConfidenceThreshold" json:"objectConfidenceThreshold"`
+	ConfidenceThreshold       float32 `yaml:"confidenceThreshold" toml:"confidenceThreshold" json:"confidenceThreshold"`
+	CoolDownPeriod            int     `yaml:"coolDownPeriod" toml:"coolDownPeriod" json:"coolDownPeriod"`
+	Logging                   bool    `yaml:"logging" toml:"logging" json:"logging"`
+	Sampling                  string  `yaml:"sampling" toml:"sampling" json:"sampling"`
+	SampleEvery               int     `yaml:"sampleEvery" toml:"sampleEvery" json:"sampleEvery"`
+	MaxFPS                    float64 `yaml:"maxFps" toml:"maxFps" json:"maxFps"`
+	Backpressure              string  `yaml:"backpressure" toml:"backpressure" json:"backpressure"`
+	GatedByMotion             bool    `yaml:"gatedByMotion" toml:"gatedByMotion" json:"gatedByMotion"`
+	MotionParameters          `yaml:",inline"`
+	TrackingParameters        `yaml:",inline"`
+	ClassParameters           `yaml:",inline"`
+	CountParameters           `yaml:",inline"`
+	LoiteringParameters       `yaml:",inline"`
+}
+
+// MotionParameters are the parameters of the `motionDetector`
+type MotionParameters struct {
+	Subtractor       string  `yaml:"subtractor" toml:"subtractor" json:"subtractor"`
+	Sensitivity      float64 `yaml:"sensitivity" toml:"sensitivity" json:"sensitivity"`
+	MinBlobArea      int     `yaml:"minBlobArea" toml:"minBlobArea" json:"minBlobArea"`
+	WarmupFrames     int     `yaml:"warmupFrames" toml:"warmupFrames" json:"warmupFrames"`
+	MotionGate       bool    `yaml:"motionGate" toml:"motionGate" json:"motionGate"`
+	MotionHoldPeriod int     `yaml:"motionHoldPeriod" toml:"motionHoldPeriod" json:"motionHoldPeriod"`
+}
+
+// TrackingParameters are the tracking parameters of the `yolo5Detector`
+type TrackingParameters struct {
+	Tracking          bool    `yaml:"tracking" toml:"tracking" json:"tracking"`
+	TrackIoUThreshold float64 `yaml:"trackIouThreshold" toml:"trackIouThreshold" json:"trackIouThreshold"`
+	TrackMinHits      int     `yaml:"trackMinHits" toml:"trackMinHits" json:"trackMinHits"`
+	TrackMaxAge       int     `yaml:"trackMaxAge" toml:"trackMaxAge" json:"trackMaxAge"`
+}
+
+// ClassParameters are the class parameters of the `yolo5Detector`
+type ClassParameters struct {
+	// Classes is the allowlist of the detected classes (i.e. "person" or "car"). Empty detects the `DefaultClass` and `ClassAll` detects every class.
+	Classes []string `yaml:"classes" toml:"classes" json:"classes"`
+	// Per-class overrides of the confidence threshold and the cool down period
+	ClassConfidenceThresholds map[string]float32 `yaml:"classConfidenceThresholds" toml:"classConfidenceThresholds" json:"classConfidenceThresholds"`
+	ClassCoolDownPeriods      map[string]int     `yaml:"classCoolDownPeriods" toml:"classCoolDownPeriods" json:"classCoolDownPeriods"`
+}
+
+// CountParameters are the parameters of the `lineCounter`
+type CountParameters struct {
+	CountPeriod    int `yaml:"countPeriod" toml:"countPeriod" json:"countPeriod"`
+	CountThreshold int `yaml:"countThreshold" toml:"countThreshold" json:"countThreshold"`
+	CountWindow    int `yaml:"countWindow" toml:"countWindow" json:"countWindow"`
+}
+
+// LoiteringParameters are the parameters of the `loiteringDetector`
+type LoiteringParameters struct {
+	DwellThreshold int `yaml:"dwellThreshold" toml:"dwellThreshold" json:"dwellThreshold"`
 }
 
+// All getters are safe to call concurrently and always return the current value.
+// Long-running processors should call them when they need a value rather than
+// caching it so that configuration changes are picked up on the fly.
 type IService interface {
 	GetModeMaxShutdownTime() int
 	GetInputFolder() string
@@ -25,9 +140,22 @@ type IService interface {
 	GetMaxAgentsPerPod() int
 	GetAgentAlerterPeriodicTimeout() int
 	GetAgentPeriodicTimeout() int
+	GetAgentLeaseDuration() int
+	GetAgentMaxRestarts() int
+	GetAgentRestartBackoff() int
+	GetAgentRestartMaxBackoff() int
+	GetAgentAbandonCooldown() int
 	GetAgentsManagerPeriodicTimeout() int
 	GetAgentsMonitorPeriodicTimeout() int
 	GetAgentsMonitorMaxOrphanedCameras() int
 	GetStreamerMaxWorkers() int
+	GetFramerMaxReadFailures() int
+	GetFramerStallTimeout() int
+	GetFramerReconnectBackoff() int
+	GetFramerReconnectMaxBackoff() int
+	GetFrameDebug() bool
 	GetStreamerParameters(name string) StreamerParameters
+	// Watch returns a channel that is signaled every time the configuration changes.
+	// The channel is closed when the context is cancelled.
+	Watch(canxCtx context.Context) <-chan struct{}
 }
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFileService loads the config file without its watcher so that the tests reload it on demand
+func newTestFileService(t *testing.T, path string) *fileService {
+	t.Helper()
+
+	// The watcher exits right away since its context is already cancelled
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	canxFn()
+
+	svc, err := NewFile(canxCtx, path)
+	if err != nil {
+		t.Fatalf("error loading config file: %v", err)
+	}
+
+	return svc.(*fileService)
+}
+
+// rewriteConfig replaces the content of a config file written by writeConfig
+func rewriteConfig(t *testing.T, path, content string) {
+	t.Helper()
+
+	content = strings.ReplaceAll(content, "$INPUT", filepath.ToSlash(filepath.Dir(path)))
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+}
+
+func TestReloadPublishesChanges(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "inputFolder: $INPUT\nmaxAgentsPerPod: 1\n")
+	svc := newTestFileService(t, path)
+
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+	changes := svc.Watch(canxCtx)
+
+	// An unchanged file is not reloaded
+	svc.reload()
+	select {
+	case <-changes:
+		t.Fatalf("unchanged config file was published")
+	default:
+	}
+
+	rewriteConfig(t, path, "inputFolder: $INPUT\nmaxAgentsPerPod: 12\nstreamers:\n  yolo5Detector:\n    coolDownPeriod: 45\n")
+	svc.reload()
+
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatalf("config change was not published")
+	}
+	if svc.GetMaxAgentsPerPod() != 12 || svc.GetStreamerParameters(Yolo5DetectorName).CoolDownPeriod != 45 {
+		t.Fatalf("getters did not return the reloaded values: %d, %+v", svc.GetMaxAgentsPerPod(), svc.GetStreamerParameters(Yolo5DetectorName))
+	}
+
+	// Cancelling the watch closes its channel
+	canxFn()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Fatalf("watch channel was signaled after its cancellation")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("watch channel was not closed")
+	}
+}
+
+func TestInvalidReloadKeepsPreviousSettings(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "inputFolder: $INPUT\nmaxAgentsPerPod: 2\n")
+	svc := newTestFileService(t, path)
+
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+	changes := svc.Watch(canxCtx)
+
+	rewriteConfig(t, path, "inputFolder: $INPUT\nmaxAgentsPerPod: 0\nstreamers:\n  yolo5Detector:\n    coolDownPeriod: 45\n")
+	svc.reload()
+
+	select {
+	case <-changes:
+		t.Fatalf("invalid config change was published")
+	default:
+	}
+	if svc.GetMaxAgentsPerPod() != 2 || svc.GetStreamerParameters(Yolo5DetectorName).CoolDownPeriod == 45 {
+		t.Fatalf("invalid config change was applied: %d, %+v", svc.GetMaxAgentsPerPod(), svc.GetStreamerParameters(Yolo5DetectorName))
+	}
+
+	// Fixing the file applies it
+	rewriteConfig(t, path, "inputFolder: $INPUT\nmaxAgentsPerPod: 5\n")
+	svc.reload()
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatalf("fixed config change was not published")
+	}
+	if svc.GetMaxAgentsPerPod() != 5 {
+		t.Fatalf("fixed config change was not applied: %d", svc.GetMaxAgentsPerPod())
+	}
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+// Camera change actions recorded in the audit trail
+const (
+	CameraCreated  = "create"
+	CameraUpdated  = "update"
+	CameraDeleted  = "delete"
+	CameraExcluded = "exclude"
+)
+
+// FramerTypes returns the framer types that cameras may use (i.e. `pipeline.FramerNames`)
+type FramerTypes func() []string
+
+// ValidateCamera checks a camera definition before it is stored.
+// Its framer type must be one of the framer types.
+func ValidateCamera(camera model.Camera, framerTypes []string) error {
+	if strings.TrimSpace(camera.ID) == "" {
+		return fmt.Errorf("%w: camera id must not be empty", ErrInvalidCamera)
+	}
+
+	if strings.TrimSpace(camera.Name) == "" {
+		return fmt.Errorf("%w: camera %s name must not be empty", ErrInvalidCamera, camera.ID)
+	}
+
+	// An empty framer type defaults to RTSP
+	framerType := camera.FramerType
+	if framerType == "" {
+		framerType = config.RTSPFramerName
+	}
+
+	known := false
+	for _, name := range framerTypes {
+		if name == framerType {
+			known = true
+			break
+		}
+	}
+
+	if !known {
+		return fmt.Errorf("%w: camera %s has an unknown framer type %q (known types: %s)", ErrInvalidCamera, camera.ID, camera.FramerType, strings.Join(framerTypes, ", "))
+	}
+
+	if framerType == config.RTSPFramerName {
+		u, err := url.Parse(camera.RtspURL)
+		if err != nil {
+			return fmt.Errorf("%w: camera %s rtsp url is not parseable: %v", ErrInvalidCamera, camera.ID, err)
+		}
+
+		if (u.Scheme != "rtsp" && u.Scheme != "rtsps") || u.Host == "" {
+			return fmt.Errorf("%w: camera %s rtsp url must be rtsp://host[:port]/path", ErrInvalidCamera, camera.ID)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, zone := range camera.Zones {
+		if strings.TrimSpace(zone.Name) == "" {
+			return fmt.Errorf("%w: camera %s zone name must not be empty", ErrInvalidCamera, camera.ID)
+		}
+
+		if names[zone.Name] {
+			return fmt.Errorf("%w: camera %s has more than one zone named %q", ErrInvalidCamera, camera.ID, zone.Name)
+		}
+		names[zone.Name] = true
+
+		if len(zone.Points) < 3 {
+			return fmt.Errorf("%w: camera %s zone %q must have at least 3 points", ErrInvalidCamera, camera.ID, zone.Name)
+		}
+
+		for _, point := range zone.Points {
+			if point.X < 0 || point.X > 1 || point.Y < 0 || point.Y > 1 {
+				return fmt.Errorf("%w: camera %s zone %q points must be normalized between 0 and 1, got (%f, %f)", ErrInvalidCamera, camera.ID, zone.Name, point.X, point.Y)
+			}
+		}
+
+		if zone.Trigger != "" && zone.Trigger != model.ZoneTriggerFootPoint && zone.Trigger != model.ZoneTriggerBox {
+			return fmt.Errorf("%w: camera %s zone %q trigger must be %s or %s, got %q", ErrInvalidCamera, camera.ID, zone.Name, model.ZoneTriggerFootPoint, model.ZoneTriggerBox, zone.Trigger)
+		}
+	}
+
+	names = map[string]bool{}
+	for _, line := range camera.Lines {
+		if strings.TrimSpace(line.Name) == "" {
+			return fmt.Errorf("%w: camera %s line name must not be empty", ErrInvalidCamera, camera.ID)
+		}
+
+		if names[line.Name] {
+			return fmt.Errorf("%w: camera %s has more than one line named %q", ErrInvalidCamera, camera.ID, line.Name)
+		}
+		names[line.Name] = true
+
+		for _, point := range []model.ZonePoint{line.From, line.To} {
+			if point.X < 0 || point.X > 1 || point.Y < 0 || point.Y > 1 {
+				return fmt.Errorf("%w: camera %s line %q ends must be normalized between 0 and 1, got (%f, %f)", ErrInvalidCamera, camera.ID, line.Name, point.X, point.Y)
+			}
+		}
+
+		if line.From == line.To {
+			return fmt.Errorf("%w: camera %s line %q ends must differ", ErrInvalidCamera, camera.ID, line.Name)
+		}
+	}
+
+	return nil
+}
+
+// PipelineChanged reports whether a camera changed in a way that requires restarting its agent
+func PipelineChanged(before, after model.Camera) bool {
+	return before.RtspURL != after.RtspURL ||
+		before.FramerType != after.FramerType ||
+		!reflect.DeepEqual(before.Streamers, after.Streamers) ||
+		!reflect.DeepEqual(before.StreamerParameters, after.StreamerParameters) ||
+		!reflect.DeepEqual(before.Zones, after.Zones) ||
+		!reflect.DeepEqual(before.Lines, after.Lines)
+}
+
+// applyCameraDefinition copies the definition fields of a camera onto a stored camera
+// leaving the agent ownership fields untouched
+func applyCameraDefinition(stored *model.Camera, camera model.Camera) {
+	stored.VMSIdentifier = camera.VMSIdentifier
+	stored.Name = camera.Name
+	stored.RtspURL = camera.RtspURL
+	stored.FramerType = camera.FramerType
+	stored.Excluded = camera.Excluded
+	stored.Streamers = camera.Streamers
+	stored.StreamerParameters = camera.StreamerParameters
+	stored.Zones = camera.Zones
+	stored.Lines = camera.Lines
+}
+
+// newCameraChange records a change. Agent ownership fields are cleared
+// since they are not part of the camera definition.
+func newCameraChange(action string, cameraID string, before, after *model.Camera) model.CameraChange {
+	return model.CameraChange{
+		Timestamp: time.Now().Unix(),
+		CameraID:  cameraID,
+		Action:    action,
+		Before:    cameraDefinition(before),
+		After:     cameraDefinition(after),
+	}
+}
+
+func cameraDefinition(camera *model.Camera) *model.Camera {
+	if camera == nil {
+		return nil
+	}
+
+	definition := model.Camera{ID: camera.ID}
+	applyCameraDefinition(&definition, *camera)
+	return &definition
+}
@@ -0,0 +1,223 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+// newTestFilesDB starts the files DB with no cameras in a temp folder
+func newTestFilesDB(t *testing.T, folder string) *filesDBService {
+	t.Helper()
+
+	cfgSvc := &testConfig{InputFolder: folder}
+	err := os.WriteFile(cfgSvc.GetCamerasInputFile(), []byte("[]"), 0o644)
+	if err != nil {
+		t.Fatalf("error writing cameras file: %v", err)
+	}
+
+	return NewFilesDB(cfgSvc, testFramerTypes).(*filesDBService)
+}
+
+func TestValidateCamera(t *testing.T) {
+	square := []model.ZonePoint{{X: 0.1, Y: 0.1}, {X: 0.9, Y: 0.1}, {X: 0.9, Y: 0.9}, {X: 0.1, Y: 0.9}}
+
+	tests := []struct {
+		name   string
+		change func(camera *model.Camera)
+		err    string
+	}{
+		{"valid", func(_ *model.Camera) {}, ""},
+		{"valid non rtsp framer without url", func(c *model.Camera) { c.FramerType = config.RandomFramerName; c.RtspURL = "" }, ""},
+		{"valid zones and lines", func(c *model.Camera) {
+			c.Zones = []model.Zone{{Name: "door", Points: square, Trigger: model.ZoneTriggerBox}}
+			c.Lines = []model.Line{{Name: "entrance", From: model.ZonePoint{X: 0, Y: 0.5}, To: model.ZonePoint{X: 1, Y: 0.5}}}
+		}, ""},
+		{"empty id", func(c *model.Camera) { c.ID = " " }, "camera id must not be empty"},
+		{"empty name", func(c *model.Camera) { c.Name = "" }, "name must not be empty"},
+		{"unknown framer type", func(c *model.Camera) { c.FramerType = "usb" }, `unknown framer type "usb"`},
+		{"rtsp url with another scheme", func(c *model.Camera) { c.RtspURL = "http://camera/stream" }, "rtsp url must be"},
+		{"rtsp url without host", func(c *model.Camera) { c.RtspURL = "rtsp:///stream" }, "rtsp url must be"},
+		{"unparseable rtsp url", func(c *model.Camera) { c.RtspURL = "rtsp://camera:port/stream" }, "rtsp url is not parseable"},
+		{"missing rtsp url", func(c *model.Camera) { c.RtspURL = "" }, "rtsp url must be"},
+		{"zone without name", func(c *model.Camera) { c.Zones = []model.Zone{{Points: square}} }, "zone name must not be empty"},
+		{"duplicate zones", func(c *model.Camera) {
+			c.Zones = []model.Zone{{Name: "door", Points: square}, {Name: "door", Points: square}}
+		}, `more than one zone named "door"`},
+		{"degenerate zone", func(c *model.Camera) { c.Zones = []model.Zone{{Name: "door", Points: square[:2]}} }, "at least 3 points"},
+		{"zone outside the frame", func(c *model.Camera) {
+			c.Zones = []model.Zone{{Name: "door", Points: []model.ZonePoint{{X: 0, Y: 0}, {X: 1.5, Y: 0}, {X: 1, Y: 1}}}}
+		}, "points must be normalized"},
+		{"unknown zone trigger", func(c *model.Camera) { c.Zones = []model.Zone{{Name: "door", Points: square, Trigger: "center"}} }, "trigger must be"},
+		{"line without name", func(c *model.Camera) {
+			c.Lines = []model.Line{{From: model.ZonePoint{X: 0, Y: 0}, To: model.ZonePoint{X: 1, Y: 1}}}
+		}, "line name must not be empty"},
+		{"duplicate lines", func(c *model.Camera) {
+			line := model.Line{Name: "entrance", From: model.ZonePoint{X: 0, Y: 0}, To: model.ZonePoint{X: 1, Y: 1}}
+			c.Lines = []model.Line{line, line}
+		}, `more than one line named "entrance"`},
+		{"degenerate line", func(c *model.Camera) {
+			c.Lines = []model.Line{{Name: "entrance", From: model.ZonePoint{X: 0.5, Y: 0.5}, To: model.ZonePoint{X: 0.5, Y: 0.5}}}
+		}, "ends must differ"},
+		{"line outside the frame", func(c *model.Camera) {
+			c.Lines = []model.Line{{Name: "entrance", From: model.ZonePoint{X: -0.1, Y: 0}, To: model.ZonePoint{X: 1, Y: 1}}}
+		}, "ends must be normalized"},
+	}
+
+	for _, test := range tests {
+		camera := testCamera("1")
+		test.change(&camera)
+
+		err := ValidateCamera(camera, testFramerTypes())
+		if test.err == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrInvalidCamera) || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("%s: expected an invalid camera error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestPipelineChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(camera *model.Camera)
+		changed bool
+	}{
+		{"name", func(c *model.Camera) { c.Name = "renamed" }, false},
+		{"vms identifier", func(c *model.Camera) { c.VMSIdentifier = "vms-2" }, false},
+		{"agent ownership", func(c *model.Camera) { c.AgentID = "agent-b"; c.LeaseExpiry = 1 }, false},
+		{"rtsp url", func(c *model.Camera) { c.RtspURL = "rtsp://camera-2/stream" }, true},
+		{"framer type", func(c *model.Camera) { c.FramerType = config.ReplayFramerName }, true},
+		{"streamers", func(c *model.Camera) { c.Streamers = []string{config.MP4RecorderName} }, true},
+		{"streamer parameters", func(c *model.Camera) {
+			c.StreamerParameters = map[string]map[string]interface{}{config.Yolo5DetectorName: {"coolDownPeriod": 5}}
+		}, true},
+		{"zones", func(c *model.Camera) { c.Zones = []model.Zone{{Name: "door"}} }, true},
+		{"lines", func(c *model.Camera) { c.Lines = []model.Line{{Name: "entrance"}} }, true},
+	}
+
+	for _, test := range tests {
+		before := testCamera("1")
+		after := testCamera("1")
+		test.change(&after)
+
+		if changed := PipelineChanged(before, after); changed != test.changed {
+			t.Fatalf("%s: expected the pipeline changed to be %v", test.name, test.changed)
+		}
+	}
+}
+
+func TestCameraChangesAreAudited(t *testing.T) {
+	backends := map[string]func(t *testing.T) IService{
+		"files": func(t *testing.T) IService {
+			return newTestFilesDB(t, t.TempDir())
+		},
+		"sqlite": func(t *testing.T) IService {
+			return newTestSQLite(t, t.TempDir())
+		},
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			svc := newBackend(t)
+
+			camera := testCamera("1")
+			err := svc.CreateCamera(camera)
+			if err != nil {
+				t.Fatalf("error creating camera: %v", err)
+			}
+			if err := svc.CreateCamera(camera); !errors.Is(err, ErrCameraExists) {
+				t.Fatalf("expected %v, got %v", ErrCameraExists, err)
+			}
+
+			// An update keeps the agent ownership
+			err = svc.ClaimCamera("1", "agent-a", 60)
+			if err != nil {
+				t.Fatalf("error claiming camera: %v", err)
+			}
+			updated := camera
+			updated.Name = "renamed"
+			err = svc.UpdateCamera(updated)
+			if err != nil {
+				t.Fatalf("error updating camera: %v", err)
+			}
+			if stored, _ := svc.RetrieveCamerasByID("1"); stored.Name != "renamed" || stored.AgentID != "agent-a" {
+				t.Fatalf("update did not keep the agent ownership: %+v", stored)
+			}
+
+			err = svc.UpdateCameraExcluded("1", true)
+			if err != nil {
+				t.Fatalf("error excluding camera: %v", err)
+			}
+
+			err = svc.DeleteCamera("1")
+			if err != nil {
+				t.Fatalf("error deleting camera: %v", err)
+			}
+
+			// Failed changes are not audited
+			if err := svc.UpdateCamera(updated); !errors.Is(err, ErrCameraNotFound) {
+				t.Fatalf("expected %v, got %v", ErrCameraNotFound, err)
+			}
+			if err := svc.DeleteCamera("1"); !errors.Is(err, ErrCameraNotFound) {
+				t.Fatalf("expected %v, got %v", ErrCameraNotFound, err)
+			}
+			invalid := testCamera("2")
+			invalid.RtspURL = ""
+			if err := svc.CreateCamera(invalid); !errors.Is(err, ErrInvalidCamera) {
+				t.Fatalf("expected %v, got %v", ErrInvalidCamera, err)
+			}
+
+			changes, err := svc.RetrieveCameraChanges("", Query{})
+			if err != nil {
+				t.Fatalf("error retrieving camera changes: %v", err)
+			}
+
+			actions := []string{}
+			for _, change := range changes {
+				actions = append(actions, change.Action)
+			}
+			if strings.Join(actions, ",") != "create,update,exclude,delete" {
+				t.Fatalf("unexpected audit trail %v", actions)
+			}
+
+			created, renamed, excluded, deleted := changes[0], changes[1], changes[2], changes[3]
+			if created.Before != nil || created.After == nil || created.After.Name != camera.Name {
+				t.Fatalf("unexpected create entry %+v", created)
+			}
+			if renamed.Before.Name != camera.Name || renamed.After.Name != "renamed" {
+				t.Fatalf("unexpected update entry %+v", renamed)
+			}
+			// Agent ownership is not part of the camera definition
+			if renamed.Before.AgentID != "" || renamed.After.AgentID != "" {
+				t.Fatalf("update entry has the agent ownership: %+v", renamed)
+			}
+			if excluded.Before.Excluded || !excluded.After.Excluded {
+				t.Fatalf("unexpected exclude entry %+v", excluded)
+			}
+			if deleted.Before == nil || !deleted.Before.Excluded || deleted.After != nil {
+				t.Fatalf("unexpected delete entry %+v", deleted)
+			}
+
+			for _, change := range changes {
+				if change.CameraID != "1" || change.Timestamp == 0 {
+					t.Fatalf("unexpected audit entry %+v", change)
+				}
+			}
+
+			// Changes are retrieved per camera
+			if other, _ := svc.RetrieveCameraChanges("2", Query{}); len(other) != 0 {
+				t.Fatalf("unexpected changes of another camera %+v", other)
+			}
+		})
+	}
+}
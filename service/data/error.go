@@ -0,0 +1,35 @@
+package data
+
+import (
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+)
+
+func newErrorRecord(err interface{}) model.ErrorRecord {
+	// Determine if the error is custom
+	var customErr model.CustomError
+	if custom, ok := err.(model.CustomError); ok {
+		customErr = custom
+	} else {
+		customErr.Processor = "N/A"
+		customErr.Inner = err.(error)
+		customErr.Message = err.(error).Error()
+		customErr.StackTrace = "N/A"
+		customErr.Misc = nil
+	}
+
+	inner := ""
+	if customErr.Inner != nil {
+		inner = customErr.Inner.Error()
+	}
+
+	return model.ErrorRecord{
+		Timestamp:  time.Now().Unix(),
+		Processor:  customErr.Processor,
+		Inner:      inner,
+		Message:    customErr.Message,
+		StackTrace: customErr.StackTrace,
+		Misc:       customErr.Misc,
+	}
+}
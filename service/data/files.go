@@ -4,19 +4,33 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/khaledhikmat/vs-go/model"
 	"github.com/khaledhikmat/vs-go/service/config"
 )
 
+// The files DB is meant for development. It is safe to share between the processes
+// of one machine (i.e. an agents manager and an agents monitor sharing `./settings`):
+// read-modify-write cycles are serialized in-process via a mutex and across processes
+// via an advisory lock, and files are replaced atomically so a crash never leaves
+// a partially written file behind.
 type filesDBService struct {
-	CfgSvc config.IService
+	CfgSvc      config.IService
+	FramerTypes FramerTypes
+	Mutex       sync.Mutex
+	// Active JSON Lines file path -> its first record timestamp (guarded by the mutex)
+	FirstTimestamps map[string]jsonlFirstTimestamp
 }
 
-func NewFilesDB(cfgsvc config.IService) IService {
+// Camera definitions are validated against the framer types
+func NewFilesDB(cfgsvc config.IService, framerTypes FramerTypes) IService {
 	return &filesDBService{
-		CfgSvc: cfgsvc,
+		CfgSvc:          cfgsvc,
+		FramerTypes:     framerTypes,
+		FirstTimestamps: map[string]jsonlFirstTimestamp{},
 	}
 }
 
@@ -80,7 +94,11 @@ func (svc *filesDBService) RetrieveOrphanedCameras(max int) ([]model.Camera, err
 	var result []model.Camera
 	now := time.Now().Unix()
 	for _, camera := range cameras {
-		if camera.AgentID == "" || now-camera.LastHeartBeat == 0 || (now-camera.LastHeartBeat > 5*60) {
+		if camera.Excluded {
+			continue
+		}
+
+		if camera.AgentID == "" || camera.LeaseExpiry < now {
 			result = append(result, camera)
 			if len(result) >= max {
 				break
@@ -92,206 +110,403 @@ func (svc *filesDBService) RetrieveOrphanedCameras(max int) ([]model.Camera, err
 }
 
 func (svc *filesDBService) UpdateCameraExcluded(id string, excluded bool) error {
-	cameras, err := svc.RetrieveCameras()
-	if err != nil {
+	var change *model.CameraChange
+	err := svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		i := findCamera(cameras, id)
+		if i < 0 {
+			return nil, fmt.Errorf("camera %s: %w", id, ErrCameraNotFound)
+		}
+
+		before := cameras[i]
+		cameras[i].Excluded = excluded
+		c := newCameraChange(CameraExcluded, id, &before, &cameras[i])
+		change = &c
+		return cameras, nil
+	})
+	if err != nil || change == nil {
 		return err
 	}
 
-	for i, camera := range cameras {
-		if camera.ID == id {
-			cameras[i].Excluded = excluded
-			break
-		}
-	}
+	return svc.newCameraChange(*change)
+}
 
-	data, err := json.Marshal(cameras)
+func (svc *filesDBService) CreateCamera(camera model.Camera) error {
+	err := ValidateCamera(camera, svc.FramerTypes())
 	if err != nil {
 		return err
 	}
 
-	output := svc.CfgSvc.GetCamerasInputFile()
-	// Write the JSON data to the file (with truncation))
-	err = os.WriteFile(output, data, 0644)
+	err = svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		if findCamera(cameras, camera.ID) >= 0 {
+			return nil, fmt.Errorf("camera %s: %w", camera.ID, ErrCameraExists)
+		}
+
+		// A new camera is always unowned
+		created := model.Camera{ID: camera.ID}
+		applyCameraDefinition(&created, camera)
+		return append(cameras, created), nil
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return svc.newCameraChange(newCameraChange(CameraCreated, camera.ID, nil, &camera))
 }
 
-func (svc *filesDBService) UpdateCameraAgentID(cameraID, agentID string) error {
-	cameras, err := svc.RetrieveCameras()
+func (svc *filesDBService) UpdateCamera(camera model.Camera) error {
+	err := ValidateCamera(camera, svc.FramerTypes())
 	if err != nil {
 		return err
 	}
 
-	for i, camera := range cameras {
-		if camera.ID == cameraID {
-			cameras[i].AgentID = agentID
-			cameras[i].StartupTime = time.Now().Unix()
-			cameras[i].LastHeartBeat = time.Now().Unix()
-			cameras[i].Uptime = cameras[i].LastHeartBeat - cameras[i].StartupTime
-			break
+	var before model.Camera
+	err = svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		i := findCamera(cameras, camera.ID)
+		if i < 0 {
+			return nil, fmt.Errorf("camera %s: %w", camera.ID, ErrCameraNotFound)
 		}
-	}
 
-	data, err := json.Marshal(cameras)
+		before = cameras[i]
+		applyCameraDefinition(&cameras[i], camera)
+		return cameras, nil
+	})
 	if err != nil {
 		return err
 	}
 
-	output := svc.CfgSvc.GetCamerasInputFile()
-	// Write the JSON data to the file (with truncation))
-	err = os.WriteFile(output, data, 0644)
+	return svc.newCameraChange(newCameraChange(CameraUpdated, camera.ID, &before, &camera))
+}
+
+// DeleteCamera removes the camera even if an agent owns it.
+// The agent finds out on its next lease renewal and stops.
+func (svc *filesDBService) DeleteCamera(id string) error {
+	var before model.Camera
+	err := svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		i := findCamera(cameras, id)
+		if i < 0 {
+			return nil, fmt.Errorf("camera %s: %w", id, ErrCameraNotFound)
+		}
+
+		before = cameras[i]
+		return append(cameras[:i], cameras[i+1:]...), nil
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return svc.newCameraChange(newCameraChange(CameraDeleted, id, &before, nil))
 }
 
-func (svc *filesDBService) UpdateCameraAgentHeartbeat(id string) error {
-	cameras, err := svc.RetrieveCameras()
+func (svc *filesDBService) RetrieveCameraChanges(cameraID string, query Query) ([]model.CameraChange, error) {
+	return queryEntities(svc, "camera-changes", query, func(change model.CameraChange) bool {
+		return cameraID == "" || change.CameraID == cameraID
+	})
+}
+
+func (svc *filesDBService) NewCameraEvent(event model.CameraEvent) error {
+	event.Timestamp = time.Now().Unix()
+	return appendEntity(svc, event, "camera-events")
+}
+
+func (svc *filesDBService) RetrieveCameraEvents(cameraID string, query Query) ([]model.CameraEvent, error) {
+	return queryEntities(svc, "camera-events", query, func(event model.CameraEvent) bool {
+		return cameraID == "" || event.CameraID == cameraID
+	})
+}
+
+// The audit trail is appended after the cameras file is written since both use the same lock
+func (svc *filesDBService) newCameraChange(change model.CameraChange) error {
+	err := appendEntity(svc, change, "camera-changes")
 	if err != nil {
-		return err
+		return fmt.Errorf("error recording camera %s change: %w", change.CameraID, err)
 	}
 
-	for i, camera := range cameras {
-		if camera.ID == id {
-			cameras[i].LastHeartBeat = time.Now().Unix()
-			cameras[i].Uptime = cameras[i].LastHeartBeat - cameras[i].StartupTime
-			break
+	return nil
+}
+
+func (svc *filesDBService) ClaimCamera(cameraID, agentID string, lease int) error {
+	return svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		i := findCamera(cameras, cameraID)
+		if i < 0 {
+			return nil, ErrCameraNotFound
 		}
-	}
 
-	data, err := json.MarshalIndent(cameras, "", "  ")
-	if err != nil {
-		return err
-	}
+		now := time.Now().Unix()
+		if cameras[i].AgentID != "" && cameras[i].AgentID != agentID && cameras[i].LeaseExpiry >= now {
+			return nil, ErrCameraClaimed
+		}
+
+		cameras[i].AgentID = agentID
+		cameras[i].StartupTime = now
+		cameras[i].LastHeartBeat = now
+		cameras[i].Uptime = 0
+		cameras[i].LeaseExpiry = now + int64(lease)
+		return cameras, nil
+	})
+}
+
+func (svc *filesDBService) RenewLease(cameraID, agentID string, lease int) error {
+	return svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		i := findCamera(cameras, cameraID)
+		if i < 0 {
+			return nil, ErrCameraNotFound
+		}
 
+		if cameras[i].AgentID != agentID {
+			return nil, ErrCameraNotOwned
+		}
+
+		now := time.Now().Unix()
+		cameras[i].LastHeartBeat = now
+		cameras[i].Uptime = cameras[i].LastHeartBeat - cameras[i].StartupTime
+		cameras[i].LeaseExpiry = now + int64(lease)
+		return cameras, nil
+	})
+}
+
+func (svc *filesDBService) ReleaseCamera(cameraID, agentID string) error {
+	return svc.updateCameras(func(cameras []model.Camera) ([]model.Camera, error) {
+		i := findCamera(cameras, cameraID)
+		if i < 0 {
+			return nil, ErrCameraNotFound
+		}
+
+		if cameras[i].AgentID != agentID {
+			return nil, ErrCameraNotOwned
+		}
+
+		cameras[i].AgentID = ""
+		cameras[i].LeaseExpiry = 0
+		return cameras, nil
+	})
+}
+
+// updateCameras runs a read-modify-write cycle on the cameras file under lock.
+// The update function returns the cameras to write and the file is only written if it succeeds.
+func (svc *filesDBService) updateCameras(update func(cameras []model.Camera) ([]model.Camera, error)) error {
 	output := svc.CfgSvc.GetCamerasInputFile()
-	// Write the JSON data to the file (with truncation))
-	err = os.WriteFile(output, data, 0644)
+
+	return svc.withLock(output, func() error {
+		cameras, err := svc.RetrieveCameras()
+		if err != nil {
+			return err
+		}
+
+		cameras, err = update(cameras)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(cameras, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		return writeFileAtomic(output, data)
+	})
+}
+
+// withLock serializes read-modify-write cycles on a file: in-process via the mutex
+// and across processes via an advisory lock on a sibling `.lock` file
+func (svc *filesDBService) withLock(path string, fn func() error) error {
+	svc.Mutex.Lock()
+	defer svc.Mutex.Unlock()
+
+	unlock, err := lockFile(path + ".lock")
 	if err != nil {
-		return err
+		return fmt.Errorf("error locking %s: %w", path, err)
 	}
+	defer unlock()
 
-	return nil
+	return fn()
 }
 
-func (svc *filesDBService) NewError(err interface{}) error {
-	// Determine if the error is custom
-	var customErr model.CustomError
-	if custom, ok := err.(model.CustomError); ok {
-		customErr = custom
-	} else {
-		customErr.Processor = "N/A"
-		customErr.Inner = err.(error)
-		customErr.Message = err.(error).Error()
-		customErr.StackTrace = "N/A"
-		customErr.Misc = nil
+func findCamera(cameras []model.Camera, id string) int {
+	for i, camera := range cameras {
+		if camera.ID == id {
+			return i
+		}
 	}
 
-	// Create an error object to persist
-	errorData := struct {
-		Timestamp  int64                  `json:"timestamp"`
-		Processor  string                 `json:"processor"`
-		Inner      string                 `json:"innerError"`
-		Message    string                 `json:"message"`
-		StackTrace string                 `json:"stackTrace"`
-		Misc       map[string]interface{} `json:"misc"`
-	}{
-		Timestamp:  time.Now().Unix(),
-		Processor:  customErr.Processor,
-		Inner:      customErr.Inner.Error(),
-		Message:    customErr.Message,
-		StackTrace: customErr.StackTrace,
-		Misc:       customErr.Misc,
-	}
-	return newEntity(errorData, "errors", svc.CfgSvc)
+	return -1
+}
+
+func (svc *filesDBService) NewError(err interface{}) error {
+	return appendEntity(svc, newErrorRecord(err), "errors")
 }
 
 func (svc *filesDBService) NewAgentsManagerStats(stats model.AgentsManagerStats) error {
 	// Marshal the stats data to JSON
 	stats.Timestamp = time.Now().Unix()
-	return newEntity(stats, "agents-manager-stats", svc.CfgSvc)
+	return appendEntity(svc, stats, "agents-manager-stats")
 }
 
 func (svc *filesDBService) NewAgentStats(stats model.AgentStats) error {
 	// Marshal the stats data to JSON
 	stats.Timestamp = time.Now().Unix()
-	return newEntity(stats, "agent-stats", svc.CfgSvc)
+	return appendEntity(svc, stats, "agent-stats")
 }
 
 func (svc *filesDBService) NewFramerStats(stats model.FramerStats) error {
 	// Marshal the stats data to JSON
 	stats.Timestamp = time.Now().Unix()
-	return newEntity(stats, "framer-stats", svc.CfgSvc)
+	return appendEntity(svc, stats, "framer-stats")
 }
 
 func (svc *filesDBService) NewStreamerStats(stats model.StreamerStats) error {
 	// Marshal the stats data to JSON
 	stats.Timestamp = time.Now().Unix()
-	return newEntity(stats, "streamer-stats", svc.CfgSvc)
+	return appendEntity(svc, stats, "streamer-stats")
 }
 
 func (svc *filesDBService) NewAlerterStats(stats model.AlerterStats) error {
 	// Marshal the stats data to JSON
 	stats.Timestamp = time.Now().Unix()
-	return newEntity(stats, "alerter-stats", svc.CfgSvc)
+	return appendEntity(svc, stats, "alerter-stats")
 }
 
-func newEntity[T any](entity T, filename string, cfgsvc config.IService) error {
-	entities, err := retrieveEntites[T](filename, cfgsvc)
+func (svc *filesDBService) NewCountStats(stats model.CountStats) error {
+	// Marshal the stats data to JSON
+	stats.Timestamp = time.Now().Unix()
+	return appendEntity(svc, stats, "count-stats")
+}
+
+// writeFileAtomic writes the data to a temp file in the same folder and renames it over the target
+// so that readers (and a crash) see either the old or the new content but never a partial file
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
 
-	entities = append(entities, entity)
+	// Clean up the temp file if anything goes wrong before the rename
+	defer os.Remove(temp.Name())
 
-	// Marshal the entity data to JSON
-	data, err := json.MarshalIndent(entities, "", "  ")
+	_, err = temp.Write(data)
 	if err != nil {
+		temp.Close()
 		return err
 	}
 
-	// Open the file in append mode, create it if it doesn't exist
-	file, fileErr := os.OpenFile(fmt.Sprintf("%s/%s.json", cfgsvc.GetInputFolder(), filename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if fileErr != nil {
-		return fileErr
+	err = temp.Sync()
+	if err != nil {
+		temp.Close()
+		return err
 	}
-	defer file.Close()
 
-	// Write the JSON data to the file (with truncation))
-	output := fmt.Sprintf("%s/%s.json", cfgsvc.GetInputFolder(), filename)
-	err = os.WriteFile(output, data, 0644)
+	err = temp.Close()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = os.Chmod(temp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
 }
 
-func retrieveEntites[T any](filename string, cfgsvc config.IService) ([]T, error) {
-	var entities []T
+func (svc *filesDBService) RetrieveErrors(query Query) ([]model.ErrorRecord, error) {
+	return queryEntities(svc, "errors", query, func(record model.ErrorRecord) bool {
+		return query.Processor == "" || record.Processor == query.Processor
+	})
+}
 
-	data, err := os.ReadFile(fmt.Sprintf("%s/%s.json", cfgsvc.GetInputFolder(), filename))
-	if err != nil {
-		// WARNIG: File not found, return empty slice
-		return entities, nil
-	}
+func (svc *filesDBService) RetrieveAgentsManagerStats(query Query) ([]model.AgentsManagerStats, error) {
+	return queryEntities(svc, "agents-manager-stats", query, func(_ model.AgentsManagerStats) bool {
+		return true
+	})
+}
 
-	entities = []T{}
-	err = json.Unmarshal([]byte(data), &entities)
-	if err != nil {
-		return entities, err
+func (svc *filesDBService) RetrieveAgentStats(query Query) ([]model.AgentStats, error) {
+	return queryEntities(svc, "agent-stats", query, func(stats model.AgentStats) bool {
+		return query.Camera == "" || stats.Camera == query.Camera
+	})
+}
+
+func (svc *filesDBService) RetrieveFramerStats(query Query) ([]model.FramerStats, error) {
+	return queryEntities(svc, "framer-stats", query, framerStatsMatcher(query))
+}
+
+func (svc *filesDBService) RetrieveStreamerStats(query Query) ([]model.StreamerStats, error) {
+	return queryEntities(svc, "streamer-stats", query, streamerStatsMatcher(query))
+}
+
+func (svc *filesDBService) RetrieveAlerterStats(query Query) ([]model.AlerterStats, error) {
+	return queryEntities(svc, "alerter-stats", query, func(stats model.AlerterStats) bool {
+		return query.Name == "" || stats.Name == query.Name
+	})
+}
+
+func (svc *filesDBService) RetrieveCountStats(query Query) ([]model.CountStats, error) {
+	return queryEntities(svc, "count-stats", query, func(stats model.CountStats) bool {
+		return (query.Camera == "" || stats.Camera == query.Camera) &&
+			(query.Name == "" || stats.Name == query.Name)
+	})
+}
+
+func (svc *filesDBService) SummarizeFramerStats(query Query) (model.StatsSummary, error) {
+	summary := statsSummarizer{}
+	match := framerStatsMatcher(query)
+
+	err := streamEntities(svc, "framer-stats", query.From, query.To, func(stats model.FramerStats) bool {
+		if match(stats) {
+			summary.add(stats.Timestamp, stats.FPS, stats.Frames, stats.Errors, 0)
+		}
+		return true
+	})
+
+	return summary.result(), err
+}
+
+func (svc *filesDBService) SummarizeStreamerStats(query Query) (model.StatsSummary, error) {
+	summary := statsSummarizer{}
+	match := streamerStatsMatcher(query)
+
+	err := streamEntities(svc, "streamer-stats", query.From, query.To, func(stats model.StreamerStats) bool {
+		if match(stats) {
+			summary.add(stats.Timestamp, stats.FPS, stats.Frames, stats.Errors, stats.AvgProcTime)
+		}
+		return true
+	})
+
+	return summary.result(), err
+}
+
+func framerStatsMatcher(query Query) func(stats model.FramerStats) bool {
+	return func(stats model.FramerStats) bool {
+		return (query.Camera == "" || stats.Camera == query.Camera) &&
+			(query.Name == "" || stats.Name == query.Name)
 	}
+}
 
-	// Unmarshal the JSON data into the slice of entities
-	err = json.Unmarshal(data, &entities)
-	if err != nil {
-		return nil, err
+func streamerStatsMatcher(query Query) func(stats model.StreamerStats) bool {
+	return func(stats model.StreamerStats) bool {
+		return (query.Camera == "" || stats.Camera == query.Camera) &&
+			(query.Name == "" || stats.Name == query.Name) &&
+			(query.Worker == nil || stats.Worker == *query.Worker)
 	}
+}
+
+// queryEntities streams the entity records in the query time range and pages through the matching ones
+func queryEntities[T any](svc *filesDBService, name string, query Query, match func(entity T) bool) ([]T, error) {
+	result := []T{}
+	skipped := 0
+
+	err := streamEntities(svc, name, query.From, query.To, func(entity T) bool {
+		if !match(entity) {
+			return true
+		}
+
+		if skipped < query.Offset {
+			skipped++
+			return true
+		}
+
+		result = append(result, entity)
+		return len(result) < query.limit()
+	})
 
-	return entities, nil
+	return result, err
 }
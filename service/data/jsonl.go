@@ -0,0 +1,281 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats and errors are stored as JSON Lines (one record per line) so that a new record
+// is a single append. The active file is `<name>.jsonl` and it is rotated to
+// `<name>.<first record timestamp>-<n>.jsonl` once it grows too big or too old.
+// The counter `n` keeps rotated files with the same first timestamp apart.
+const (
+	jsonlMaxFileSize = 10 * 1024 * 1024 // bytes
+	jsonlMaxFileAge  = 24 * time.Hour
+	// Stack traces make error records long so allow long lines when reading
+	jsonlMaxLineSize = 10 * 1024 * 1024
+)
+
+// Only the timestamp is decoded to filter records before decoding them fully
+type jsonlTimestamp struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
+// jsonlFirstTimestamp caches the first record timestamp of an active file so that appends
+// do not scan it. The file info tells whether the file was rotated (i.e. by another process) since.
+type jsonlFirstTimestamp struct {
+	info  os.FileInfo
+	first int64
+}
+
+func (svc *filesDBService) entityFile(name string) string {
+	return fmt.Sprintf("%s/%s.jsonl", svc.CfgSvc.GetInputFolder(), name)
+}
+
+func appendEntity[T any](svc *filesDBService, entity T, name string) error {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	output := svc.entityFile(name)
+
+	// The lock keeps appends from interleaving with a rotation in another process
+	return svc.withLock(output, func() error {
+		err := svc.rotateEntityFile(output)
+		if err != nil {
+			return fmt.Errorf("error rotating %s: %w", output, err)
+		}
+
+		file, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = file.Write(data)
+		return err
+	})
+}
+
+// rotateEntityFile renames the active file if it reached the max size or age.
+// The age is measured from the first record in the file.
+// It must be called with the file locked.
+func (svc *filesDBService) rotateEntityFile(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		delete(svc.FirstTimestamps, path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cached, ok := svc.FirstTimestamps[path]
+	if !ok || !os.SameFile(cached.info, info) {
+		first, err := firstTimestamp(path)
+		if err != nil {
+			return err
+		}
+		cached = jsonlFirstTimestamp{info: info, first: first}
+		svc.FirstTimestamps[path] = cached
+	}
+
+	age := time.Since(time.Unix(cached.first, 0))
+	if info.Size() < jsonlMaxFileSize && age < jsonlMaxFileAge {
+		return nil
+	}
+
+	// The first record is unreadable so the file is named after its last change which
+	// keeps it between the files rotated before and after it
+	start := cached.first
+	if start == 0 {
+		start = info.ModTime().Unix()
+	}
+
+	err = rotateFile(path, start)
+	if err != nil {
+		return err
+	}
+
+	delete(svc.FirstTimestamps, path)
+	return nil
+}
+
+// rotateFile renames the active file after its start without replacing a rotated file
+func rotateFile(path string, start int64) error {
+	prefix := strings.TrimSuffix(path, ".jsonl")
+	for n := 0; ; n++ {
+		rotated := fmt.Sprintf("%s.%d-%d.jsonl", prefix, start, n)
+
+		// Unlike a rename, a link fails if the rotated file exists
+		err := os.Link(path, rotated)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return os.Remove(path)
+	}
+}
+
+func firstTimestamp(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), jsonlMaxLineSize)
+	if !scanner.Scan() {
+		return time.Now().Unix(), scanner.Err()
+	}
+
+	var ts jsonlTimestamp
+	err = json.Unmarshal(scanner.Bytes(), &ts)
+	if err != nil {
+		// The first record is unreadable. Rotate the file right away.
+		return 0, nil
+	}
+
+	return ts.Timestamp, nil
+}
+
+// streamEntities reads the records (oldest first) whose timestamp is within [from, to]
+// and passes them to fn. A zero `to` means no upper bound. Streaming stops when fn returns false.
+// Rotated files that cannot contain records in the range are skipped without being read.
+func streamEntities[T any](svc *filesDBService, name string, from, to int64, fn func(entity T) bool) error {
+	files, err := entityFiles(svc.entityFile(name))
+	if err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		// A file ends where the next one starts (if known)
+		if i+1 < len(files) && files[i+1].start > 0 && files[i+1].start < from {
+			continue
+		}
+
+		if to > 0 && file.start > to {
+			break
+		}
+
+		more, err := streamEntityFile(file.path, from, to, fn)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func streamEntityFile[T any](path string, from, to int64, fn func(entity T) bool) (bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Rotated away since we listed it. Its records are in the next file.
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), jsonlMaxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// WARNING: A crash may leave a partial last line behind so unreadable lines are skipped
+		var ts jsonlTimestamp
+		if json.Unmarshal(line, &ts) != nil {
+			continue
+		}
+
+		if ts.Timestamp < from || (to > 0 && ts.Timestamp > to) {
+			continue
+		}
+
+		var entity T
+		if json.Unmarshal(line, &entity) != nil {
+			continue
+		}
+
+		if !fn(entity) {
+			return false, nil
+		}
+	}
+
+	return true, scanner.Err()
+}
+
+type jsonlFile struct {
+	path  string
+	start int64
+	n     int
+}
+
+// entityFiles returns the rotated files in chronological order followed by the active file
+func entityFiles(active string) ([]jsonlFile, error) {
+	prefix := strings.TrimSuffix(active, ".jsonl")
+	matches, err := filepath.Glob(prefix + ".*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []jsonlFile{}
+	// Glob cleans the matched paths (i.e. drops a leading `./`) so only the file names are compared
+	name := filepath.Base(prefix)
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), name+"."), ".jsonl")
+
+		// Files rotated before the counter was added have no `-<n>`
+		startText, nText, counted := strings.Cut(suffix, "-")
+		start, err := strconv.ParseInt(startText, 10, 64)
+		if err != nil {
+			// Not a rotated file of this entity
+			continue
+		}
+
+		n := 0
+		if counted {
+			n, err = strconv.Atoi(nText)
+			if err != nil {
+				continue
+			}
+		}
+
+		files = append(files, jsonlFile{path: match, start: start, n: n})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].start == files[j].start {
+			return files[i].n < files[j].n
+		}
+		return files[i].start < files[j].start
+	})
+
+	start, err := firstTimestamp(active)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(files, jsonlFile{path: active, start: start}), nil
+}
@@ -0,0 +1,272 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+// appendStreamerStats appends the stats with their own timestamps (unlike NewStreamerStats)
+func appendStreamerStats(t *testing.T, svc *filesDBService, stats ...model.StreamerStats) {
+	t.Helper()
+
+	for _, s := range stats {
+		err := appendEntity(svc, s, "streamer-stats")
+		if err != nil {
+			t.Fatalf("error appending streamer stats: %v", err)
+		}
+	}
+}
+
+func TestJSONLRotatesOldFiles(t *testing.T) {
+	folder := t.TempDir()
+	svc := newTestFilesDB(t, folder)
+
+	now := time.Now().Unix()
+	old := now - int64(2*jsonlMaxFileAge/time.Second)
+	appendStreamerStats(t, svc, model.StreamerStats{Name: "old", Timestamp: old})
+
+	// The active file started too long ago so the next append rotates it
+	appendStreamerStats(t, svc,
+		model.StreamerStats{Name: "new", Timestamp: now},
+		model.StreamerStats{Name: "newer", Timestamp: now + 1},
+	)
+
+	files, err := entityFiles(svc.entityFile("streamer-stats"))
+	if err != nil {
+		t.Fatalf("error listing files: %v", err)
+	}
+	if len(files) != 2 || files[0].start != old || files[1].path != svc.entityFile("streamer-stats") || files[1].start != now {
+		t.Fatalf("unexpected files %+v", files)
+	}
+
+	// Reads go across the rotated and the active files, oldest first
+	stats, err := svc.RetrieveStreamerStats(Query{})
+	if err != nil {
+		t.Fatalf("error retrieving stats: %v", err)
+	}
+	if len(stats) != 3 || stats[0].Name != "old" || stats[1].Name != "new" || stats[2].Name != "newer" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// A range after the rotated file only reads the active file
+	stats, err = svc.RetrieveStreamerStats(Query{From: now})
+	if err != nil || len(stats) != 2 || stats[0].Name != "new" {
+		t.Fatalf("unexpected stats %+v, %v", stats, err)
+	}
+
+	// And a range before the active file only reads the rotated one
+	stats, err = svc.RetrieveStreamerStats(Query{To: old})
+	if err != nil || len(stats) != 1 || stats[0].Name != "old" {
+		t.Fatalf("unexpected stats %+v, %v", stats, err)
+	}
+}
+
+func TestJSONLRotationKeepsFilesWithTheSameStart(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "errors.jsonl")
+
+	for i := 0; i < 3; i++ {
+		err := os.WriteFile(path, []byte(`{"timestamp":100}`+"\n"), 0o644)
+		if err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+
+		err = rotateFile(path, 100)
+		if err != nil {
+			t.Fatalf("error rotating file: %v", err)
+		}
+	}
+
+	files, err := entityFiles(path)
+	if err != nil {
+		t.Fatalf("error listing files: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("rotated files were replaced: %+v", files)
+	}
+	for n, file := range files {
+		if file.start != 100 || file.n != n {
+			t.Fatalf("unexpected file order %+v", files)
+		}
+	}
+}
+
+func TestJSONLSkipsUnreadableLines(t *testing.T) {
+	folder := t.TempDir()
+	svc := newTestFilesDB(t, folder)
+
+	now := time.Now().Unix()
+	appendStreamerStats(t, svc, model.StreamerStats{Name: "first", Timestamp: now})
+
+	// A crash left a partial line behind
+	file, err := os.OpenFile(svc.entityFile("streamer-stats"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	_, _ = fmt.Fprintf(file, "{\"timestamp\":%d,\"na\n", now)
+	file.Close()
+
+	appendStreamerStats(t, svc, model.StreamerStats{Name: "second", Timestamp: now + 1})
+
+	stats, err := svc.RetrieveStreamerStats(Query{})
+	if err != nil || len(stats) != 2 || stats[0].Name != "first" || stats[1].Name != "second" {
+		t.Fatalf("unexpected stats %+v, %v", stats, err)
+	}
+}
+
+func TestJSONLQueryFilters(t *testing.T) {
+	svc := newTestFilesDB(t, t.TempDir())
+
+	// The records are seconds apart
+	now := time.Now().Unix()
+	appendStreamerStats(t, svc,
+		model.StreamerStats{Name: config.Yolo5DetectorName, Camera: "front", Worker: 0, Timestamp: now + 10},
+		model.StreamerStats{Name: config.Yolo5DetectorName, Camera: "front", Worker: 1, Timestamp: now + 20},
+		model.StreamerStats{Name: config.MP4RecorderName, Camera: "front", Worker: -1, Timestamp: now + 30},
+		model.StreamerStats{Name: config.Yolo5DetectorName, Camera: "back", Worker: 0, Timestamp: now + 40},
+	)
+
+	worker := 1
+	tests := []struct {
+		name    string
+		query   Query
+		offsets []int64
+	}{
+		{"all", Query{}, []int64{10, 20, 30, 40}},
+		{"camera", Query{Camera: "front"}, []int64{10, 20, 30}},
+		{"name", Query{Name: config.Yolo5DetectorName}, []int64{10, 20, 40}},
+		{"worker", Query{Worker: &worker}, []int64{20}},
+		{"time range", Query{From: now + 20, To: now + 30}, []int64{20, 30}},
+		{"combined", Query{Camera: "front", Name: config.Yolo5DetectorName, From: now + 15}, []int64{20}},
+		{"offset", Query{Camera: "front", Offset: 1}, []int64{20, 30}},
+		{"offset and limit", Query{Offset: 1, Limit: 2}, []int64{20, 30}},
+	}
+
+	for _, test := range tests {
+		stats, err := svc.RetrieveStreamerStats(test.query)
+		if err != nil {
+			t.Fatalf("%s: error retrieving stats: %v", test.name, err)
+		}
+
+		timestamps := []int64{}
+		for _, s := range stats {
+			timestamps = append(timestamps, s.Timestamp-now)
+		}
+		if len(timestamps) != len(test.offsets) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.offsets, timestamps)
+		}
+		for i := range timestamps {
+			if timestamps[i] != test.offsets[i] {
+				t.Fatalf("%s: expected %v, got %v", test.name, test.offsets, timestamps)
+			}
+		}
+	}
+}
+
+func TestQueryLimit(t *testing.T) {
+	tests := []struct {
+		limit    int
+		expected int
+	}{
+		{0, defaultQueryLimit},
+		{-5, defaultQueryLimit},
+		{5, 5},
+		{maxQueryLimit, maxQueryLimit},
+		{maxQueryLimit + 1, maxQueryLimit},
+	}
+
+	for _, test := range tests {
+		if limit := (Query{Limit: test.limit}).limit(); limit != test.expected {
+			t.Fatalf("limit %d: expected %d, got %d", test.limit, test.expected, limit)
+		}
+	}
+
+	// Queries return the default number of records unless asked for more, but never more than the max
+	svc := newTestFilesDB(t, t.TempDir())
+	now := time.Now().Unix()
+	for i := 0; i < maxQueryLimit+10; i++ {
+		appendStreamerStats(t, svc, model.StreamerStats{Timestamp: now})
+	}
+
+	for _, test := range tests {
+		stats, err := svc.RetrieveStreamerStats(Query{Limit: test.limit})
+		if err != nil || len(stats) != test.expected {
+			t.Fatalf("limit %d: expected %d records, got %d (%v)", test.limit, test.expected, len(stats), err)
+		}
+	}
+}
+
+func TestSummarizeStreamerStats(t *testing.T) {
+	backends := map[string]func(t *testing.T) IService{
+		"files": func(t *testing.T) IService {
+			return newTestFilesDB(t, t.TempDir())
+		},
+		"sqlite": func(t *testing.T) IService {
+			return newTestSQLite(t, t.TempDir())
+		},
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			svc := newBackend(t)
+
+			for _, stats := range []model.StreamerStats{
+				{Name: config.Yolo5DetectorName, Camera: "front", FPS: 4, Frames: 100, Errors: 5, AvgProcTime: 10},
+				{Name: config.Yolo5DetectorName, Camera: "front", FPS: 6, Frames: 300, Errors: 15, AvgProcTime: 30},
+				{Name: config.Yolo5DetectorName, Camera: "back", FPS: 30, Frames: 1000, Errors: 0, AvgProcTime: 1},
+			} {
+				err := svc.NewStreamerStats(stats)
+				if err != nil {
+					t.Fatalf("error writing stats: %v", err)
+				}
+			}
+
+			// Offset and limit do not apply to summaries
+			summary, err := svc.SummarizeStreamerStats(Query{Camera: "front", Limit: 1, Offset: 1})
+			if err != nil {
+				t.Fatalf("error summarizing stats: %v", err)
+			}
+
+			if summary.Records != 2 || summary.Frames != 400 || summary.Errors != 20 {
+				t.Fatalf("unexpected totals %+v", summary)
+			}
+			if math.Abs(summary.AvgFPS-5) > 1e-9 || math.Abs(summary.AvgProcTime-20) > 1e-9 || math.Abs(summary.ErrorRate-0.05) > 1e-9 {
+				t.Fatalf("unexpected averages %+v", summary)
+			}
+			if summary.From == 0 || summary.To < summary.From {
+				t.Fatalf("unexpected time range %+v", summary)
+			}
+
+			// Nothing matches
+			summary, err = svc.SummarizeStreamerStats(Query{Camera: "side"})
+			if err != nil || summary != (model.StatsSummary{}) {
+				t.Fatalf("unexpected empty summary %+v, %v", summary, err)
+			}
+		})
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	tests := []struct {
+		errors, frames int64
+		rate           float64
+	}{
+		{0, 0, 0},
+		{3, 0, 1},
+		{0, 10, 0},
+		{5, 20, 0.25},
+	}
+
+	for _, test := range tests {
+		if rate := errorRate(test.errors, test.frames); rate != test.rate {
+			t.Fatalf("%d errors for %d frames: expected %f, got %f", test.errors, test.frames, test.rate, rate)
+		}
+	}
+}
@@ -0,0 +1,985 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+
+	// Pure Go SQLite driver registered as `sqlite`
+	_ "modernc.org/sqlite"
+)
+
+// Schema migrations are applied in order and each one exactly once.
+// Never edit a released migration: append a new one instead.
+var sqliteMigrations = []string{
+	// 1: cameras, errors and stats
+	`
+	CREATE TABLE cameras (
+		id                  TEXT PRIMARY KEY,
+		vms_id              TEXT NOT NULL DEFAULT '',
+		name                TEXT NOT NULL DEFAULT '',
+		rtsp_url            TEXT NOT NULL DEFAULT '',
+		framer_type         TEXT NOT NULL DEFAULT '',
+		excluded            INTEGER NOT NULL DEFAULT 0,
+		agent_id            TEXT NOT NULL DEFAULT '',
+		startup_time        INTEGER NOT NULL DEFAULT 0,
+		last_heartbeat      INTEGER NOT NULL DEFAULT 0,
+		uptime              INTEGER NOT NULL DEFAULT 0,
+		streamers           TEXT NOT NULL DEFAULT '[]',
+		streamer_parameters TEXT NOT NULL DEFAULT '{}'
+	);
+	CREATE INDEX idx_cameras_agent_heartbeat ON cameras (agent_id, last_heartbeat);
+
+	CREATE TABLE errors (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp   INTEGER NOT NULL,
+		processor   TEXT NOT NULL,
+		inner_error TEXT NOT NULL,
+		message     TEXT NOT NULL,
+		stack_trace TEXT NOT NULL,
+		misc        TEXT NOT NULL DEFAULT '{}'
+	);
+	CREATE INDEX idx_errors_timestamp ON errors (timestamp);
+	CREATE INDEX idx_errors_processor ON errors (processor, timestamp);
+
+	CREATE TABLE agents_manager_stats (
+		id                                INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp                         INTEGER NOT NULL,
+		orphaned_requests                 INTEGER NOT NULL,
+		orphaned_request_subscriptions    INTEGER NOT NULL,
+		orphaned_request_unsubscriptions  INTEGER NOT NULL,
+		running_agents                    INTEGER NOT NULL,
+		running_agents_uptime             INTEGER NOT NULL,
+		avg_running_agents_per_min        REAL NOT NULL
+	);
+	CREATE INDEX idx_agents_manager_stats_timestamp ON agents_manager_stats (timestamp);
+
+	CREATE TABLE agent_stats (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		agent_id  TEXT NOT NULL,
+		camera    TEXT NOT NULL,
+		uptime    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_agent_stats_camera ON agent_stats (camera, timestamp);
+
+	CREATE TABLE framer_stats (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp      INTEGER NOT NULL,
+		name           TEXT NOT NULL,
+		camera         TEXT NOT NULL,
+		fps            INTEGER NOT NULL,
+		frames         INTEGER NOT NULL,
+		skipped_frames INTEGER NOT NULL,
+		errors         INTEGER NOT NULL,
+		uptime         INTEGER NOT NULL
+	);
+	CREATE INDEX idx_framer_stats_camera ON framer_stats (camera, timestamp);
+
+	CREATE TABLE streamer_stats (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp     INTEGER NOT NULL,
+		name          TEXT NOT NULL,
+		worker        INTEGER NOT NULL,
+		camera        TEXT NOT NULL,
+		fps           INTEGER NOT NULL,
+		frames        INTEGER NOT NULL,
+		errors        INTEGER NOT NULL,
+		uptime        INTEGER NOT NULL,
+		avg_proc_time REAL NOT NULL
+	);
+	CREATE INDEX idx_streamer_stats_camera ON streamer_stats (camera, name, timestamp);
+
+	CREATE TABLE alerter_stats (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		name      TEXT NOT NULL,
+		alerts    INTEGER NOT NULL,
+		errors    INTEGER NOT NULL,
+		uptime    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_alerter_stats_timestamp ON alerter_stats (timestamp);
+	`,
+	// 2: lease-based camera ownership
+	`
+	ALTER TABLE cameras ADD COLUMN lease_expiry INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX idx_cameras_lease_expiry ON cameras (lease_expiry);
+	`,
+	// 3: camera changes audit trail
+	`
+	CREATE TABLE camera_changes (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		camera_id TEXT NOT NULL,
+		action    TEXT NOT NULL,
+		before    TEXT NOT NULL DEFAULT 'null',
+		after     TEXT NOT NULL DEFAULT 'null'
+	);
+	CREATE INDEX idx_camera_changes_camera ON camera_changes (camera_id, timestamp);
+	`,
+	// 4: camera events
+	`
+	CREATE TABLE camera_events (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		camera_id TEXT NOT NULL,
+		camera    TEXT NOT NULL,
+		type      TEXT NOT NULL,
+		message   TEXT NOT NULL
+	);
+	CREATE INDEX idx_camera_events_camera ON camera_events (camera_id, timestamp);
+	`,
+	// 5: agent supervision stats
+	`
+	ALTER TABLE agents_manager_stats ADD COLUMN agent_failures INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE agents_manager_stats ADD COLUMN agent_restarts INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE agents_manager_stats ADD COLUMN abandoned_cameras INTEGER NOT NULL DEFAULT 0;
+	`,
+	// 6: streamer backpressure stats
+	`
+	ALTER TABLE streamer_stats ADD COLUMN dropped_frames INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE streamer_stats ADD COLUMN avg_queue_depth REAL NOT NULL DEFAULT 0;
+	ALTER TABLE streamer_stats ADD COLUMN max_queue_depth INTEGER NOT NULL DEFAULT 0;
+	`,
+	// 7: camera zones
+	`
+	ALTER TABLE cameras ADD COLUMN zones TEXT NOT NULL DEFAULT '[]';
+	`,
+	// 8: camera lines and their counts
+	`
+	ALTER TABLE cameras ADD COLUMN lines TEXT NOT NULL DEFAULT '[]';
+
+	CREATE TABLE count_stats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		camera TEXT NOT NULL,
+		line TEXT NOT NULL,
+		count_in INTEGER NOT NULL,
+		count_out INTEGER NOT NULL,
+		period INTEGER NOT NULL
+	);
+	CREATE INDEX idx_count_stats_camera ON count_stats (camera, name, timestamp);
+	`,
+}
+
+const cameraColumns = `id, vms_id, name, rtsp_url, framer_type, excluded, agent_id, startup_time, last_heartbeat, uptime, lease_expiry, streamers, streamer_parameters, zones, lines`
+
+type sqliteDBService struct {
+	CfgSvc      config.IService
+	FramerTypes FramerTypes
+	DB          *sql.DB
+}
+
+// NewSQLite opens (or creates) the SQLite database file and migrates it to the latest schema.
+// If the database has no cameras yet, they are seeded from the cameras input file (if any)
+// so that an existing files DB deployment can switch over without losing its cameras.
+// Camera definitions are validated against the framer types.
+func NewSQLite(cfgsvc config.IService, dbFile string, framerTypes FramerTypes) (IService, error) {
+	// WAL allows readers to proceed while a writer is active and the busy timeout
+	// makes concurrent writers (i.e. other processes) wait instead of failing
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)", dbFile)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database %s: %w", dbFile, err)
+	}
+
+	// SQLite allows one writer at a time so a single connection serializes writers
+	// within the pod rather than having them compete for the database lock
+	db.SetMaxOpenConns(1)
+
+	svc := &sqliteDBService{
+		CfgSvc:      cfgsvc,
+		FramerTypes: framerTypes,
+		DB:          db,
+	}
+
+	err = svc.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = svc.seedCameras()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+func (svc *sqliteDBService) RetrieveCameras() ([]model.Camera, error) {
+	return svc.queryCameras(`SELECT ` + cameraColumns + ` FROM cameras ORDER BY id`)
+}
+
+func (svc *sqliteDBService) RetrieveCamerasByID(id string) (model.Camera, error) {
+	cameras, err := svc.queryCameras(`SELECT `+cameraColumns+` FROM cameras WHERE id = ?`, id)
+	if err != nil || len(cameras) == 0 {
+		return model.Camera{}, err
+	}
+
+	return cameras[0], nil
+}
+
+func (svc *sqliteDBService) RetrieveCamerasByIDs(ids []string) ([]model.Camera, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	return svc.queryCameras(`SELECT `+cameraColumns+` FROM cameras WHERE id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (svc *sqliteDBService) RetrieveOrphanedCameras(max int) ([]model.Camera, error) {
+	now := time.Now().Unix()
+	return svc.queryCameras(`SELECT `+cameraColumns+` FROM cameras
+		WHERE excluded = 0 AND (agent_id = '' OR lease_expiry < ?)
+		ORDER BY lease_expiry
+		LIMIT ?`, now, max)
+}
+
+func (svc *sqliteDBService) UpdateCameraExcluded(id string, excluded bool) error {
+	return svc.inTx(func(tx *sql.Tx) error {
+		before, found, err := retrieveCamera(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("camera %s: %w", id, ErrCameraNotFound)
+		}
+
+		_, err = tx.Exec(`UPDATE cameras SET excluded = ? WHERE id = ?`, excluded, id)
+		if err != nil {
+			return err
+		}
+
+		after := before
+		after.Excluded = excluded
+		return insertCameraChange(tx, newCameraChange(CameraExcluded, id, &before, &after))
+	})
+}
+
+// Camera changes and their audit trail entries are written in the same transaction
+func (svc *sqliteDBService) CreateCamera(camera model.Camera) error {
+	err := ValidateCamera(camera, svc.FramerTypes())
+	if err != nil {
+		return err
+	}
+
+	return svc.inTx(func(tx *sql.Tx) error {
+		_, found, err := retrieveCamera(tx, camera.ID)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			return fmt.Errorf("camera %s: %w", camera.ID, ErrCameraExists)
+		}
+
+		// A new camera is always unowned
+		created := model.Camera{ID: camera.ID}
+		applyCameraDefinition(&created, camera)
+		err = insertCamera(tx, created)
+		if err != nil {
+			return err
+		}
+
+		return insertCameraChange(tx, newCameraChange(CameraCreated, camera.ID, nil, &camera))
+	})
+}
+
+func (svc *sqliteDBService) UpdateCamera(camera model.Camera) error {
+	err := ValidateCamera(camera, svc.FramerTypes())
+	if err != nil {
+		return err
+	}
+
+	streamers, err := json.Marshal(camera.Streamers)
+	if err != nil {
+		return err
+	}
+
+	parameters, err := json.Marshal(camera.StreamerParameters)
+	if err != nil {
+		return err
+	}
+
+	zones, err := json.Marshal(camera.Zones)
+	if err != nil {
+		return err
+	}
+
+	lines, err := json.Marshal(camera.Lines)
+	if err != nil {
+		return err
+	}
+
+	return svc.inTx(func(tx *sql.Tx) error {
+		before, found, err := retrieveCamera(tx, camera.ID)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("camera %s: %w", camera.ID, ErrCameraNotFound)
+		}
+
+		_, err = tx.Exec(`UPDATE cameras
+			SET vms_id = ?, name = ?, rtsp_url = ?, framer_type = ?, excluded = ?, streamers = ?, streamer_parameters = ?, zones = ?, lines = ?
+			WHERE id = ?`,
+			camera.VMSIdentifier, camera.Name, camera.RtspURL, camera.FramerType, camera.Excluded,
+			string(streamers), string(parameters), string(zones), string(lines), camera.ID)
+		if err != nil {
+			return err
+		}
+
+		return insertCameraChange(tx, newCameraChange(CameraUpdated, camera.ID, &before, &camera))
+	})
+}
+
+// DeleteCamera removes the camera even if an agent owns it.
+// The agent finds out on its next lease renewal and stops.
+func (svc *sqliteDBService) DeleteCamera(id string) error {
+	return svc.inTx(func(tx *sql.Tx) error {
+		before, found, err := retrieveCamera(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("camera %s: %w", id, ErrCameraNotFound)
+		}
+
+		_, err = tx.Exec(`DELETE FROM cameras WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+
+		return insertCameraChange(tx, newCameraChange(CameraDeleted, id, &before, nil))
+	})
+}
+
+func (svc *sqliteDBService) RetrieveCameraChanges(cameraID string, query Query) ([]model.CameraChange, error) {
+	where, args := sqlWhere(Query{From: query.From, To: query.To, Camera: cameraID}, "camera_id", "", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, camera_id, action, before, after FROM camera_changes`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.CameraChange{}
+	for rows.Next() {
+		var change model.CameraChange
+		var before, after string
+		err := rows.Scan(&change.Timestamp, &change.CameraID, &change.Action, &before, &after)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(before), &change.Before)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling camera %s change: %w", change.CameraID, err)
+		}
+
+		err = json.Unmarshal([]byte(after), &change.After)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling camera %s change: %w", change.CameraID, err)
+		}
+		result = append(result, change)
+	}
+
+	return result, rows.Err()
+}
+
+// ClaimCamera is a compare-and-set: the update only applies if the camera is free,
+// already owned by the agent or its lease has expired
+func (svc *sqliteDBService) ClaimCamera(cameraID, agentID string, lease int) error {
+	now := time.Now().Unix()
+	result, err := svc.DB.Exec(`UPDATE cameras
+		SET agent_id = ?, startup_time = ?, last_heartbeat = ?, uptime = 0, lease_expiry = ?
+		WHERE id = ? AND (agent_id = '' OR agent_id = ? OR lease_expiry < ?)`,
+		agentID, now, now, now+int64(lease), cameraID, agentID, now)
+	if err != nil {
+		return err
+	}
+
+	return svc.checkOwnership(result, cameraID, ErrCameraClaimed)
+}
+
+func (svc *sqliteDBService) RenewLease(cameraID, agentID string, lease int) error {
+	now := time.Now().Unix()
+	result, err := svc.DB.Exec(`UPDATE cameras
+		SET last_heartbeat = ?, uptime = ? - startup_time, lease_expiry = ?
+		WHERE id = ? AND agent_id = ?`,
+		now, now, now+int64(lease), cameraID, agentID)
+	if err != nil {
+		return err
+	}
+
+	return svc.checkOwnership(result, cameraID, ErrCameraNotOwned)
+}
+
+func (svc *sqliteDBService) ReleaseCamera(cameraID, agentID string) error {
+	result, err := svc.DB.Exec(`UPDATE cameras
+		SET agent_id = '', lease_expiry = 0
+		WHERE id = ? AND agent_id = ?`, cameraID, agentID)
+	if err != nil {
+		return err
+	}
+
+	return svc.checkOwnership(result, cameraID, ErrCameraNotOwned)
+}
+
+// checkOwnership turns a conditional update that did not apply into
+// either ErrCameraNotFound or the provided ownership error
+func (svc *sqliteDBService) checkOwnership(result sql.Result, cameraID string, ownershipErr error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected > 0 {
+		return nil
+	}
+
+	var count int
+	err = svc.DB.QueryRow(`SELECT COUNT(*) FROM cameras WHERE id = ?`, cameraID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrCameraNotFound
+	}
+
+	return ownershipErr
+}
+
+func (svc *sqliteDBService) NewError(err interface{}) error {
+	record := newErrorRecord(err)
+
+	misc, jsonErr := json.Marshal(record.Misc)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	_, dbErr := svc.DB.Exec(`INSERT INTO errors (timestamp, processor, inner_error, message, stack_trace, misc)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		record.Timestamp, record.Processor, record.Inner, record.Message, record.StackTrace, string(misc))
+	return dbErr
+}
+
+func (svc *sqliteDBService) NewAgentsManagerStats(stats model.AgentsManagerStats) error {
+	stats.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO agents_manager_stats (timestamp, orphaned_requests, orphaned_request_subscriptions,
+		orphaned_request_unsubscriptions, running_agents, running_agents_uptime, avg_running_agents_per_min,
+		agent_failures, agent_restarts, abandoned_cameras)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stats.Timestamp, stats.TotalOrphanedRequests, stats.TotalOrphanedRequestSubscriptions,
+		stats.TotalOrphanedRequestUnsubscriptions, stats.TotalRunningAgents, stats.TotalRunningAgentsUptime,
+		stats.AvgRunningAgentsPerMin, stats.TotalAgentFailures, stats.TotalAgentRestarts, stats.TotalAbandonedCameras)
+	return err
+}
+
+func (svc *sqliteDBService) NewAgentStats(stats model.AgentStats) error {
+	stats.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO agent_stats (timestamp, agent_id, camera, uptime) VALUES (?, ?, ?, ?)`,
+		stats.Timestamp, stats.ID, stats.Camera, stats.Uptime)
+	return err
+}
+
+func (svc *sqliteDBService) NewFramerStats(stats model.FramerStats) error {
+	stats.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO framer_stats (timestamp, name, camera, fps, frames, skipped_frames, errors, uptime)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		stats.Timestamp, stats.Name, stats.Camera, stats.FPS, stats.Frames, stats.SkippedFrames, stats.Errors, stats.Uptime)
+	return err
+}
+
+func (svc *sqliteDBService) NewStreamerStats(stats model.StreamerStats) error {
+	stats.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO streamer_stats (timestamp, name, worker, camera, fps, frames, errors, uptime, avg_proc_time, dropped_frames, avg_queue_depth, max_queue_depth)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stats.Timestamp, stats.Name, stats.Worker, stats.Camera, stats.FPS, stats.Frames, stats.Errors, stats.Uptime, stats.AvgProcTime,
+		stats.DroppedFrames, stats.AvgQueueDepth, stats.MaxQueueDepth)
+	return err
+}
+
+func (svc *sqliteDBService) NewAlerterStats(stats model.AlerterStats) error {
+	stats.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO alerter_stats (timestamp, name, alerts, errors, uptime) VALUES (?, ?, ?, ?, ?)`,
+		stats.Timestamp, stats.Name, stats.Alerts, stats.Errors, stats.Uptime)
+	return err
+}
+
+func (svc *sqliteDBService) NewCountStats(stats model.CountStats) error {
+	stats.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO count_stats (timestamp, name, camera, line, count_in, count_out, period) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		stats.Timestamp, stats.Name, stats.Camera, stats.Line, stats.In, stats.Out, stats.Period)
+	return err
+}
+
+// migrate applies the pending schema migrations. Each migration runs in its own transaction
+// together with the version bump so that a failed migration leaves the schema untouched.
+func (svc *sqliteDBService) migrate() error {
+	_, err := svc.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at INTEGER NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema migrations table: %w", err)
+	}
+
+	var current int
+	err = svc.DB.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("error retrieving schema version: %w", err)
+	}
+
+	for i := current; i < len(sqliteMigrations); i++ {
+		version := i + 1
+		err = svc.inTx(func(tx *sql.Tx) error {
+			_, err := tx.Exec(sqliteMigrations[i])
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error applying schema migration %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+func (svc *sqliteDBService) seedCameras() error {
+	var count int
+	err := svc.DB.QueryRow(`SELECT COUNT(*) FROM cameras`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error counting cameras: %w", err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(svc.CfgSvc.GetCamerasInputFile())
+	if err != nil {
+		// WARNING: No cameras input file, start with an empty database
+		return nil
+	}
+
+	cameras := []model.Camera{}
+	err = json.Unmarshal(data, &cameras)
+	if err != nil {
+		return fmt.Errorf("error seeding cameras from %s: %w", svc.CfgSvc.GetCamerasInputFile(), err)
+	}
+
+	return svc.inTx(func(tx *sql.Tx) error {
+		for _, camera := range cameras {
+			err := insertCamera(tx, camera)
+			if err != nil {
+				return fmt.Errorf("error seeding camera %s: %w", camera.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *sqliteDBService) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := svc.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (svc *sqliteDBService) queryCameras(query string, args ...interface{}) ([]model.Camera, error) {
+	rows, err := svc.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cameras := []model.Camera{}
+	for rows.Next() {
+		camera, err := scanCamera(rows)
+		if err != nil {
+			return nil, err
+		}
+		cameras = append(cameras, camera)
+	}
+
+	return cameras, rows.Err()
+}
+
+func scanCamera(rows *sql.Rows) (model.Camera, error) {
+	var camera model.Camera
+	var streamers, parameters, zones, lines string
+
+	err := rows.Scan(&camera.ID, &camera.VMSIdentifier, &camera.Name, &camera.RtspURL, &camera.FramerType,
+		&camera.Excluded, &camera.AgentID, &camera.StartupTime, &camera.LastHeartBeat, &camera.Uptime,
+		&camera.LeaseExpiry, &streamers, &parameters, &zones, &lines)
+	if err != nil {
+		return camera, err
+	}
+
+	err = json.Unmarshal([]byte(streamers), &camera.Streamers)
+	if err != nil {
+		return camera, fmt.Errorf("error unmarshalling camera %s streamers: %w", camera.ID, err)
+	}
+
+	err = json.Unmarshal([]byte(parameters), &camera.StreamerParameters)
+	if err != nil {
+		return camera, fmt.Errorf("error unmarshalling camera %s streamer parameters: %w", camera.ID, err)
+	}
+
+	err = json.Unmarshal([]byte(zones), &camera.Zones)
+	if err != nil {
+		return camera, fmt.Errorf("error unmarshalling camera %s zones: %w", camera.ID, err)
+	}
+
+	err = json.Unmarshal([]byte(lines), &camera.Lines)
+	if err != nil {
+		return camera, fmt.Errorf("error unmarshalling camera %s lines: %w", camera.ID, err)
+	}
+
+	return camera, nil
+}
+
+func (svc *sqliteDBService) NewCameraEvent(event model.CameraEvent) error {
+	event.Timestamp = time.Now().Unix()
+	_, err := svc.DB.Exec(`INSERT INTO camera_events (timestamp, camera_id, camera, type, message) VALUES (?, ?, ?, ?, ?)`,
+		event.Timestamp, event.CameraID, event.Camera, event.Type, event.Message)
+	return err
+}
+
+func (svc *sqliteDBService) RetrieveCameraEvents(cameraID string, query Query) ([]model.CameraEvent, error) {
+	where, args := sqlWhere(Query{From: query.From, To: query.To, Camera: cameraID}, "camera_id", "", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, camera_id, camera, type, message FROM camera_events`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.CameraEvent{}
+	for rows.Next() {
+		var event model.CameraEvent
+		err := rows.Scan(&event.Timestamp, &event.CameraID, &event.Camera, &event.Type, &event.Message)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	return result, rows.Err()
+}
+
+// retrieveCamera reads a camera within a transaction. It must not go through svc.DB
+// since the transaction holds the only connection.
+func retrieveCamera(tx *sql.Tx, id string) (model.Camera, bool, error) {
+	rows, err := tx.Query(`SELECT `+cameraColumns+` FROM cameras WHERE id = ?`, id)
+	if err != nil {
+		return model.Camera{}, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return model.Camera{}, false, rows.Err()
+	}
+
+	camera, err := scanCamera(rows)
+	return camera, err == nil, err
+}
+
+func insertCameraChange(tx *sql.Tx, change model.CameraChange) error {
+	before, err := json.Marshal(change.Before)
+	if err != nil {
+		return err
+	}
+
+	after, err := json.Marshal(change.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO camera_changes (timestamp, camera_id, action, before, after) VALUES (?, ?, ?, ?, ?)`,
+		change.Timestamp, change.CameraID, change.Action, string(before), string(after))
+	return err
+}
+
+func insertCamera(tx *sql.Tx, camera model.Camera) error {
+	streamers, err := json.Marshal(camera.Streamers)
+	if err != nil {
+		return err
+	}
+
+	parameters, err := json.Marshal(camera.StreamerParameters)
+	if err != nil {
+		return err
+	}
+
+	zones, err := json.Marshal(camera.Zones)
+	if err != nil {
+		return err
+	}
+
+	lines, err := json.Marshal(camera.Lines)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO cameras (`+cameraColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		camera.ID, camera.VMSIdentifier, camera.Name, camera.RtspURL, camera.FramerType, camera.Excluded,
+		camera.AgentID, camera.StartupTime, camera.LastHeartBeat, camera.Uptime, camera.LeaseExpiry,
+		string(streamers), string(parameters), string(zones), string(lines))
+	return err
+}
+
+func (svc *sqliteDBService) RetrieveErrors(query Query) ([]model.ErrorRecord, error) {
+	where, args := sqlWhere(query, "", "", "", "processor")
+	rows, err := svc.DB.Query(`SELECT timestamp, processor, inner_error, message, stack_trace, misc FROM errors`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []model.ErrorRecord{}
+	for rows.Next() {
+		var record model.ErrorRecord
+		var misc string
+		err := rows.Scan(&record.Timestamp, &record.Processor, &record.Inner, &record.Message, &record.StackTrace, &misc)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(misc), &record.Misc)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling error misc: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (svc *sqliteDBService) RetrieveAgentsManagerStats(query Query) ([]model.AgentsManagerStats, error) {
+	where, args := sqlWhere(query, "", "", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, orphaned_requests, orphaned_request_subscriptions, orphaned_request_unsubscriptions,
+		running_agents, running_agents_uptime, avg_running_agents_per_min, agent_failures, agent_restarts, abandoned_cameras
+		FROM agents_manager_stats`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.AgentsManagerStats{}
+	for rows.Next() {
+		var stats model.AgentsManagerStats
+		err := rows.Scan(&stats.Timestamp, &stats.TotalOrphanedRequests, &stats.TotalOrphanedRequestSubscriptions,
+			&stats.TotalOrphanedRequestUnsubscriptions, &stats.TotalRunningAgents, &stats.TotalRunningAgentsUptime,
+			&stats.AvgRunningAgentsPerMin, &stats.TotalAgentFailures, &stats.TotalAgentRestarts, &stats.TotalAbandonedCameras)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (svc *sqliteDBService) RetrieveAgentStats(query Query) ([]model.AgentStats, error) {
+	where, args := sqlWhere(query, "camera", "", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, agent_id, camera, uptime FROM agent_stats`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.AgentStats{}
+	for rows.Next() {
+		var stats model.AgentStats
+		err := rows.Scan(&stats.Timestamp, &stats.ID, &stats.Camera, &stats.Uptime)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (svc *sqliteDBService) RetrieveFramerStats(query Query) ([]model.FramerStats, error) {
+	where, args := sqlWhere(query, "camera", "name", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, name, camera, fps, frames, skipped_frames, errors, uptime FROM framer_stats`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.FramerStats{}
+	for rows.Next() {
+		var stats model.FramerStats
+		err := rows.Scan(&stats.Timestamp, &stats.Name, &stats.Camera, &stats.FPS, &stats.Frames, &stats.SkippedFrames, &stats.Errors, &stats.Uptime)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (svc *sqliteDBService) RetrieveStreamerStats(query Query) ([]model.StreamerStats, error) {
+	where, args := sqlWhere(query, "camera", "name", "worker", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, name, worker, camera, fps, frames, errors, uptime, avg_proc_time, dropped_frames, avg_queue_depth, max_queue_depth FROM streamer_stats`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.StreamerStats{}
+	for rows.Next() {
+		var stats model.StreamerStats
+		err := rows.Scan(&stats.Timestamp, &stats.Name, &stats.Worker, &stats.Camera, &stats.FPS, &stats.Frames, &stats.Errors, &stats.Uptime, &stats.AvgProcTime,
+			&stats.DroppedFrames, &stats.AvgQueueDepth, &stats.MaxQueueDepth)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (svc *sqliteDBService) RetrieveAlerterStats(query Query) ([]model.AlerterStats, error) {
+	where, args := sqlWhere(query, "", "name", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, name, alerts, errors, uptime FROM alerter_stats`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.AlerterStats{}
+	for rows.Next() {
+		var stats model.AlerterStats
+		err := rows.Scan(&stats.Timestamp, &stats.Name, &stats.Alerts, &stats.Errors, &stats.Uptime)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (svc *sqliteDBService) RetrieveCountStats(query Query) ([]model.CountStats, error) {
+	where, args := sqlWhere(query, "camera", "name", "", "")
+	rows, err := svc.DB.Query(`SELECT timestamp, name, camera, line, count_in, count_out, period FROM count_stats`+where+sqlPage(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []model.CountStats{}
+	for rows.Next() {
+		var stats model.CountStats
+		err := rows.Scan(&stats.Timestamp, &stats.Name, &stats.Camera, &stats.Line, &stats.In, &stats.Out, &stats.Period)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (svc *sqliteDBService) SummarizeFramerStats(query Query) (model.StatsSummary, error) {
+	where, args := sqlWhere(query, "camera", "name", "", "")
+	return svc.summarize(`SELECT COUNT(*), COALESCE(SUM(frames), 0), COALESCE(SUM(errors), 0), COALESCE(AVG(fps), 0), 0,
+		COALESCE(MIN(timestamp), 0), COALESCE(MAX(timestamp), 0) FROM framer_stats`+where, args...)
+}
+
+func (svc *sqliteDBService) SummarizeStreamerStats(query Query) (model.StatsSummary, error) {
+	where, args := sqlWhere(query, "camera", "name", "worker", "")
+	return svc.summarize(`SELECT COUNT(*), COALESCE(SUM(frames), 0), COALESCE(SUM(errors), 0), COALESCE(AVG(fps), 0), COALESCE(AVG(avg_proc_time), 0),
+		COALESCE(MIN(timestamp), 0), COALESCE(MAX(timestamp), 0) FROM streamer_stats`+where, args...)
+}
+
+func (svc *sqliteDBService) summarize(query string, args ...interface{}) (model.StatsSummary, error) {
+	var summary model.StatsSummary
+	err := svc.DB.QueryRow(query, args...).Scan(&summary.Records, &summary.Frames, &summary.Errors,
+		&summary.AvgFPS, &summary.AvgProcTime, &summary.From, &summary.To)
+	if err != nil {
+		return summary, err
+	}
+
+	summary.ErrorRate = errorRate(summary.Errors, summary.Frames)
+	return summary, nil
+}
+
+// sqlWhere builds the where clause of a query. Empty column names mean that
+// the corresponding filter does not apply to the table.
+func sqlWhere(query Query, cameraColumn, nameColumn, workerColumn, processorColumn string) (string, []interface{}) {
+	clauses := []string{}
+	args := []interface{}{}
+
+	add := func(clause string, arg interface{}) {
+		clauses = append(clauses, clause)
+		args = append(args, arg)
+	}
+
+	if query.From > 0 {
+		add("timestamp >= ?", query.From)
+	}
+
+	if query.To > 0 {
+		add("timestamp <= ?", query.To)
+	}
+
+	if cameraColumn != "" && query.Camera != "" {
+		add(cameraColumn+" = ?", query.Camera)
+	}
+
+	if nameColumn != "" && query.Name != "" {
+		add(nameColumn+" = ?", query.Name)
+	}
+
+	if workerColumn != "" && query.Worker != nil {
+		add(workerColumn+" = ?", *query.Worker)
+	}
+
+	if processorColumn != "" && query.Processor != "" {
+		add(processorColumn+" = ?", query.Processor)
+	}
+
+	if len(clauses) == 0 {
+		return "", args
+	}
+
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+func sqlPage(query Query) string {
+	return fmt.Sprintf(" ORDER BY timestamp, id LIMIT %d OFFSET %d", query.limit(), max(query.Offset, 0))
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/vs-go/model"
+	"github.com/khaledhikmat/vs-go/service/config"
+)
+
+// testConfig keeps the input folder (cameras and JSON Lines files) in a temp folder.
+// The methods that the tests do not need are left to the embedded (nil) interface.
+type testConfig struct {
+	config.IService
+	InputFolder string
+}
+
+func (svc *testConfig) GetInputFolder() string {
+	return svc.InputFolder
+}
+
+func (svc *testConfig) GetCamerasInputFile() string {
+	return filepath.Join(svc.InputFolder, "cameras.json")
+}
+
+func testFramerTypes() []string {
+	return []string{config.RTSPFramerName, config.RandomFramerName, config.ReplayFramerName, config.PatternFramerName}
+}
+
+func newTestSQLite(t *testing.T, folder string) *sqliteDBService {
+	t.Helper()
+
+	svc, err := NewSQLite(&testConfig{InputFolder: folder}, filepath.Join(folder, "vs.db"), testFramerTypes)
+	if err != nil {
+		t.Fatalf("error opening sqlite database: %v", err)
+	}
+
+	db := svc.(*sqliteDBService)
+	t.Cleanup(func() {
+		db.DB.Close()
+	})
+	return db
+}
+
+func testCamera(id string) model.Camera {
+	return model.Camera{
+		ID:      id,
+		Name:    "camera " + id,
+		RtspURL: "rtsp://camera-" + id + ":554/stream",
+	}
+}
+
+func TestSQLiteMigratesEmptyDatabase(t *testing.T) {
+	folder := t.TempDir()
+	svc := newTestSQLite(t, folder)
+
+	var version, applied int
+	err := svc.DB.QueryRow(`SELECT MAX(version), COUNT(*) FROM schema_migrations`).Scan(&version, &applied)
+	if err != nil {
+		t.Fatalf("error retrieving schema version: %v", err)
+	}
+	if version != len(sqliteMigrations) || applied != len(sqliteMigrations) {
+		t.Fatalf("expected %d migrations, got version %d with %d applied", len(sqliteMigrations), version, applied)
+	}
+
+	// Every table of the latest schema is usable
+	err = svc.CreateCamera(testCamera("1"))
+	if err != nil {
+		t.Fatalf("error creating camera: %v", err)
+	}
+	err = svc.NewStreamerStats(model.StreamerStats{Name: config.Yolo5DetectorName, Camera: "1", DroppedFrames: 2})
+	if err != nil {
+		t.Fatalf("error writing streamer stats: %v", err)
+	}
+	err = svc.NewCountStats(model.CountStats{Name: config.LineCounterName, Camera: "1", Line: "door", In: 1})
+	if err != nil {
+		t.Fatalf("error writing count stats: %v", err)
+	}
+
+	// Reopening the database does not apply the migrations again
+	svc.DB.Close()
+	reopened := newTestSQLite(t, folder)
+	err = reopened.DB.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied)
+	if err != nil {
+		t.Fatalf("error retrieving schema version: %v", err)
+	}
+	if applied != len(sqliteMigrations) {
+		t.Fatalf("migrations were applied again: %d", applied)
+	}
+	if camera, _ := reopened.RetrieveCamerasByID("1"); camera.ID != "1" {
+		t.Fatalf("camera was lost when reopening the database")
+	}
+}
+
+func TestSQLiteClaimContention(t *testing.T) {
+	svc := newTestSQLite(t, t.TempDir())
+	err := svc.CreateCamera(testCamera("1"))
+	if err != nil {
+		t.Fatalf("error creating camera: %v", err)
+	}
+
+	err = svc.ClaimCamera("1", "agent-a", 60)
+	if err != nil {
+		t.Fatalf("error claiming a free camera: %v", err)
+	}
+
+	// Another agent cannot take an unexpired lease
+	err = svc.ClaimCamera("1", "agent-b", 60)
+	if !errors.Is(err, ErrCameraClaimed) {
+		t.Fatalf("expected %v, got %v", ErrCameraClaimed, err)
+	}
+
+	// The owner may claim it again
+	err = svc.ClaimCamera("1", "agent-a", 60)
+	if err != nil {
+		t.Fatalf("error claiming an owned camera: %v", err)
+	}
+
+	camera, _ := svc.RetrieveCamerasByID("1")
+	if camera.AgentID != "agent-a" || camera.LeaseExpiry <= time.Now().Unix() {
+		t.Fatalf("camera is not leased to its owner: %+v", camera)
+	}
+
+	err = svc.ClaimCamera("2", "agent-a", 60)
+	if !errors.Is(err, ErrCameraNotFound) {
+		t.Fatalf("expected %v, got %v", ErrCameraNotFound, err)
+	}
+}
+
+func TestSQLiteClaimExpiredLease(t *testing.T) {
+	svc := newTestSQLite(t, t.TempDir())
+	err := svc.CreateCamera(testCamera("1"))
+	if err != nil {
+		t.Fatalf("error creating camera: %v", err)
+	}
+
+	err = svc.ClaimCamera("1", "agent-a", 60)
+	if err != nil {
+		t.Fatalf("error claiming camera: %v", err)
+	}
+
+	// The owner stopped renewing its lease
+	_, err = svc.DB.Exec(`UPDATE cameras SET lease_expiry = ? WHERE id = ?`, time.Now().Unix()-1, "1")
+	if err != nil {
+		t.Fatalf("error expiring lease: %v", err)
+	}
+
+	orphaned, err := svc.RetrieveOrphanedCameras(10)
+	if err != nil || len(orphaned) != 1 {
+		t.Fatalf("expired camera is not orphaned: %v, %v", orphaned, err)
+	}
+
+	err = svc.ClaimCamera("1", "agent-b", 60)
+	if err != nil {
+		t.Fatalf("error claiming an expired lease: %v", err)
+	}
+
+	// The previous owner lost the camera
+	err = svc.RenewLease("1", "agent-a", 60)
+	if !errors.Is(err, ErrCameraNotOwned) {
+		t.Fatalf("expected %v, got %v", ErrCameraNotOwned, err)
+	}
+}
+
+func TestSQLiteRenewLease(t *testing.T) {
+	svc := newTestSQLite(t, t.TempDir())
+	err := svc.CreateCamera(testCamera("1"))
+	if err != nil {
+		t.Fatalf("error creating camera: %v", err)
+	}
+
+	err = svc.ClaimCamera("1", "agent-a", 1)
+	if err != nil {
+		t.Fatalf("error claiming camera: %v", err)
+	}
+
+	err = svc.RenewLease("1", "agent-a", 60)
+	if err != nil {
+		t.Fatalf("error renewing lease: %v", err)
+	}
+	camera, _ := svc.RetrieveCamerasByID("1")
+	if camera.LeaseExpiry < time.Now().Unix()+59 {
+		t.Fatalf("lease was not extended: %+v", camera)
+	}
+
+	err = svc.RenewLease("1", "agent-b", 60)
+	if !errors.Is(err, ErrCameraNotOwned) {
+		t.Fatalf("expected %v, got %v", ErrCameraNotOwned, err)
+	}
+
+	err = svc.RenewLease("2", "agent-a", 60)
+	if !errors.Is(err, ErrCameraNotFound) {
+		t.Fatalf("expected %v, got %v", ErrCameraNotFound, err)
+	}
+}
+
+func TestSQLiteReleaseCamera(t *testing.T) {
+	svc := newTestSQLite(t, t.TempDir())
+	err := svc.CreateCamera(testCamera("1"))
+	if err != nil {
+		t.Fatalf("error creating camera: %v", err)
+	}
+
+	err = svc.ClaimCamera("1", "agent-a", 60)
+	if err != nil {
+		t.Fatalf("error claiming camera: %v", err)
+	}
+
+	// Only the owner releases the camera
+	err = svc.ReleaseCamera("1", "agent-b")
+	if !errors.Is(err, ErrCameraNotOwned) {
+		t.Fatalf("expected %v, got %v", ErrCameraNotOwned, err)
+	}
+
+	err = svc.ReleaseCamera("1", "agent-a")
+	if err != nil {
+		t.Fatalf("error releasing camera: %v", err)
+	}
+
+	camera, _ := svc.RetrieveCamerasByID("1")
+	if camera.AgentID != "" || camera.LeaseExpiry != 0 {
+		t.Fatalf("camera was not released: %+v", camera)
+	}
+
+	// A released camera is orphaned right away and can be claimed by another agent
+	orphaned, err := svc.RetrieveOrphanedCameras(10)
+	if err != nil || len(orphaned) != 1 {
+		t.Fatalf("released camera is not orphaned: %v, %v", orphaned, err)
+	}
+	err = svc.ClaimCamera("1", "agent-b", 60)
+	if err != nil {
+		t.Fatalf("error claiming a released camera: %v", err)
+	}
+
+	err = svc.ReleaseCamera("1", "agent-a")
+	if !errors.Is(err, ErrCameraNotOwned) {
+		t.Fatalf("expected %v, got %v", ErrCameraNotOwned, err)
+	}
+}
@@ -0,0 +1,50 @@
+package data
+
+import "github.com/khaledhikmat/vs-go/model"
+
+// statsSummarizer accumulates framer or streamer stats into a summary
+type statsSummarizer struct {
+	summary       model.StatsSummary
+	totalFPS      float64
+	totalProcTime float64
+}
+
+func (s *statsSummarizer) add(timestamp int64, fps, frames, errors int, procTime float64) {
+	if s.summary.Records == 0 || timestamp < s.summary.From {
+		s.summary.From = timestamp
+	}
+
+	if timestamp > s.summary.To {
+		s.summary.To = timestamp
+	}
+
+	s.summary.Records++
+	s.summary.Frames += int64(frames)
+	s.summary.Errors += int64(errors)
+	s.totalFPS += float64(fps)
+	s.totalProcTime += procTime
+}
+
+func (s *statsSummarizer) result() model.StatsSummary {
+	summary := s.summary
+	if summary.Records > 0 {
+		summary.AvgFPS = s.totalFPS / float64(summary.Records)
+		summary.AvgProcTime = s.totalProcTime / float64(summary.Records)
+	}
+
+	summary.ErrorRate = errorRate(summary.Errors, summary.Frames)
+	return summary
+}
+
+// errorRate is the number of errors per frame. Errors without frames are a 100% error rate.
+func errorRate(errors, frames int64) float64 {
+	if frames > 0 {
+		return float64(errors) / float64(frames)
+	}
+
+	if errors > 0 {
+		return 1
+	}
+
+	return 0
+}
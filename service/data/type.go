@@ -1,15 +1,81 @@
 package data
 
-import "github.com/khaledhikmat/vs-go/model"
+import (
+	"errors"
+
+	"github.com/khaledhikmat/vs-go/model"
+)
+
+var (
+	ErrCameraNotFound = errors.New("camera not found")
+	ErrCameraClaimed  = errors.New("camera is claimed by another agent")
+	ErrCameraNotOwned = errors.New("camera is not owned by the agent")
+	ErrCameraExists   = errors.New("camera already exists")
+	ErrInvalidCamera  = errors.New("invalid camera")
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// Query filters historical stats and errors. Zero values do not filter.
+// Not every filter applies to every record type:
+// - Camera: agent, framer, streamer and count stats
+// - Name: framer, streamer, alerter and count stats
+// - Worker: streamer stats (-1 is a single-worker streamer i.e. the mp4 recorder)
+// - Processor: errors
+// Camera changes and events are filtered by time only (the camera id is passed separately).
+// Records are returned oldest first.
+type Query struct {
+	Camera    string
+	Name      string
+	Worker    *int
+	Processor string
+	From      int64 // Unix timestamp (inclusive)
+	To        int64 // Unix timestamp (inclusive)
+	Offset    int
+	Limit     int // Defaults to 100 and is capped at 1000
+}
+
+func (q Query) limit() int {
+	if q.Limit <= 0 {
+		return defaultQueryLimit
+	}
+
+	if q.Limit > maxQueryLimit {
+		return maxQueryLimit
+	}
+
+	return q.Limit
+}
 
 type IService interface {
 	RetrieveCameras() ([]model.Camera, error)
 	RetrieveCamerasByID(id string) (model.Camera, error)
 	RetrieveCamerasByIDs(ids []string) ([]model.Camera, error)
+	// Excluded cameras are never orphaned
 	RetrieveOrphanedCameras(max int) ([]model.Camera, error)
+	// UpdateCameraExcluded fails with ErrCameraNotFound if the camera does not exist
 	UpdateCameraExcluded(id string, excluded bool) error
-	UpdateCameraAgentID(cameraID, agentID string) error
-	UpdateCameraAgentHeartbeat(id string) error
+
+	// Camera definitions are validated (see ValidateCamera) and every change is recorded in an audit trail.
+	// UpdateCamera replaces the camera definition but keeps its agent ownership.
+	CreateCamera(camera model.Camera) error
+	UpdateCamera(camera model.Camera) error
+	DeleteCamera(id string) error
+	RetrieveCameraChanges(cameraID string, query Query) ([]model.CameraChange, error)
+
+	NewCameraEvent(event model.CameraEvent) error
+	RetrieveCameraEvents(cameraID string, query Query) ([]model.CameraEvent, error)
+
+	// Camera ownership is lease-based: an agent claims a camera for `lease` seconds
+	// and must renew the lease before it expires. An expired lease can be claimed by another agent.
+	// ClaimCamera fails with ErrCameraClaimed if another agent holds an unexpired lease.
+	// RenewLease and ReleaseCamera fail with ErrCameraNotOwned if the agent no longer owns the camera.
+	ClaimCamera(cameraID, agentID string, lease int) error
+	RenewLease(cameraID, agentID string, lease int) error
+	ReleaseCamera(cameraID, agentID string) error
 
 	NewError(err interface{}) error
 	NewAgentsManagerStats(stats model.AgentsManagerStats) error
@@ -17,4 +83,16 @@ type IService interface {
 	NewFramerStats(stats model.FramerStats) error
 	NewStreamerStats(stats model.StreamerStats) error
 	NewAlerterStats(stats model.AlerterStats) error
+	NewCountStats(stats model.CountStats) error
+
+	RetrieveErrors(query Query) ([]model.ErrorRecord, error)
+	RetrieveAgentsManagerStats(query Query) ([]model.AgentsManagerStats, error)
+	RetrieveAgentStats(query Query) ([]model.AgentStats, error)
+	RetrieveFramerStats(query Query) ([]model.FramerStats, error)
+	RetrieveStreamerStats(query Query) ([]model.StreamerStats, error)
+	RetrieveAlerterStats(query Query) ([]model.AlerterStats, error)
+	RetrieveCountStats(query Query) ([]model.CountStats, error)
+	// Summaries aggregate all the records that match the query (offset and limit are ignored)
+	SummarizeFramerStats(query Query) (model.StatsSummary, error)
+	SummarizeStreamerStats(query Query) (model.StatsSummary, error)
 }
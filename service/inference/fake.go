@@ -14,10 +14,3 @@ func (svc *fakeService) Invoke(_ string, _ string) (Result, error) {
 		AlertImageURL: "",
 	}, nil
 }
-
-// Only 1 of out 10 frames is processed
-// This is just a placeholder for the actual implementation
-func (svc *fakeService) CanSkipFrame(frames int) bool {
-	return frames%10 != 0
-	// return false
-}
@@ -6,8 +6,6 @@ type Result struct {
 	AlertImageURL string `json:"alertImageUrl"`
 }
 
-// There should be more input to CanSkipFrame than just frames
 type IService interface {
 	Invoke(modelName string, inputURL string) (Result, error)
-	CanSkipFrame(frames int) bool
 }
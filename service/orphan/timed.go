@@ -43,7 +43,8 @@ func NewTimed(canxCtx context.Context, cfgSvc config.IService, dataSvc data.ISer
 }
 
 func (svc *timedService) Publish(_ []model.Camera) error {
-	// This cannot be implemented in this service
+	// This cannot be implemented in this service. Cameras are given back by releasing
+	// them in the data service which orphans them right away.
 	return nil
 }
 